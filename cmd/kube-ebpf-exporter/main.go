@@ -1,36 +1,73 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
 	"github.com/ahas-sigs/kube-ebpf-exporter/config"
+	"github.com/ahas-sigs/kube-ebpf-exporter/decoder"
 	"github.com/ahas-sigs/kube-ebpf-exporter/exporter"
+	"github.com/ahas-sigs/kube-ebpf-exporter/kernel"
+	"github.com/ahas-sigs/kube-ebpf-exporter/kube"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/prometheus/common/version"
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
-	yaml "gopkg.in/yaml.v2"
 )
 
 func main() {
 	listenAddress := kingpin.Flag("web.listen-address", "The address to listen on for HTTP requests").Default(":9435").String()
+	enableOpenMetrics := enableOpenMetricsFlag(kingpin.CommandLine)
 	nodeID := kingpin.Flag("node-id", "node id").Default("localhost").String()
-	configFile := kingpin.Flag("config.file", "Config file path").Default("config.yaml").File()
+	configFile := kingpin.Flag("config.file", "Config file path").Default("config.yaml").String()
+	configDir := kingpin.Flag("config.dir", "Directory with config files to load instead of config.file").String()
+	labels := kingpin.Flag("label", "External label to add to metrics and sink records as name=value, can be repeated").StringMap()
 	debug := kingpin.Flag("debug", "Enable debug").Bool()
+	skipFailed := kingpin.Flag("attach.skip-failed", "Keep running with programs that attached when others fail to attach, instead of exiting").Bool()
+	shutdownTimeout := kingpin.Flag("shutdown-timeout", "How long to wait for the sink to be flushed on shutdown").Default("10s").Duration()
+	kingpin.Command("serve", "Attach programs and serve metrics").Default()
+	validateCommand := kingpin.Command("validate", "Check the config file for problems without loading programs")
+	checkCommand := kingpin.Command("check", "Compile programs and check their tables without attaching them")
 	kingpin.Version(version.Print("ebpf_exporter"))
 	kingpin.HelpFlag.Short('h')
-	kingpin.Parse()
+	command := kingpin.Parse()
 
-	config := config.Config{}
+	if command == validateCommand.FullCommand() {
+		os.Exit(validate(*configFile, *configDir))
+	}
+
+	config, err := loadConfig(*configFile, *configDir)
+	if err != nil {
+		log.Fatalf("Error reading config: %s", err)
+	}
+
+	// Check compiles variants for all kernels, not only for the running one
+	if command == checkCommand.FullCommand() {
+		os.Exit(check(config, *nodeID))
+	}
 
-	err := yaml.NewDecoder(*configFile).Decode(&config)
+	config, err = prepareConfig(config, *nodeID)
 	if err != nil {
-		log.Fatalf("Error reading config file: %s", err)
+		log.Fatalf("Error preparing config for the running kernel: %s", err)
 	}
 
-	e := exporter.New(*nodeID, config)
-	err = e.Attach()
+	config, err = resolveExternalLabels(config, *labels, *nodeID)
+	if err != nil {
+		log.Fatalf("Error resolving external labels: %s", err)
+	}
+
+	e, err := exporter.New(*nodeID, config)
+	if err != nil {
+		log.Fatalf("Error creating exporter: %s", err)
+	}
+
+	err = e.Attach(*skipFailed)
 	if err != nil {
 		log.Fatalf("Error attaching exporter: %s", err)
 	}
@@ -42,16 +79,204 @@ func main() {
 		log.Fatalf("Error registering exporter: %s", err)
 	}
 
-	http.Handle("/metrics", promhttp.Handler())
+	http.Handle("/metrics", metricsHandler(prometheus.DefaultRegisterer, prometheus.DefaultGatherer, *enableOpenMetrics))
+	http.HandleFunc("/events", e.EventsHandler)
+	http.HandleFunc("/programs", e.ProgramsHandler)
 
 	if *debug {
 		log.Printf("Debug enabled, exporting raw tables on /tables")
 		http.HandleFunc("/tables", e.TablesHandler)
 	}
 
-	log.Printf("Listening on %s", *listenAddress)
-	err = http.ListenAndServe(*listenAddress, nil)
+	server := &http.Server{Addr: *listenAddress}
+	server.RegisterOnShutdown(e.StopEventsHandler)
+
+	go func() {
+		log.Printf("Listening on %s", *listenAddress)
+		err := server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Error listening on %s: %s", *listenAddress, err)
+		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+
+	sig := <-signals
+	log.Printf("Received %s, shutting down", sig)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+
+	err = server.Shutdown(ctx)
+	if err != nil {
+		log.Printf("Error shutting down http server: %s", err)
+	}
+
+	err = e.Close(ctx)
+	if err != nil {
+		log.Printf("Error closing exporter: %s", err)
+	}
+
+	log.Printf("Shutdown complete")
+}
+
+// enableOpenMetricsFlag defines the flag that lets scrapers negotiate
+// OpenMetrics, it is off by default, because OpenMetrics exposes counters
+// without _total suffix differently from the text format
+func enableOpenMetricsFlag(app *kingpin.Application) *bool {
+	return app.Flag("web.enable-openmetrics", "Serve OpenMetrics with exemplars to scrapers that negotiate it").Default("false").Bool()
+}
+
+// metricsHandler serves metrics of the gatherer, OpenMetrics is
+// the only format with exemplars
+func metricsHandler(registerer prometheus.Registerer, gatherer prometheus.Gatherer, enableOpenMetrics bool) http.Handler {
+	return promhttp.InstrumentMetricHandler(
+		registerer,
+		promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{EnableOpenMetrics: enableOpenMetrics}),
+	)
+}
+
+// loadConfig loads config files from the directory if it is set
+// or the config file otherwise
+func loadConfig(configFile string, configDir string) (config.Config, error) {
+	if configDir != "" {
+		return config.LoadDir(configDir)
+	}
+
+	return config.LoadFile(configFile)
+}
+
+// prepareConfig keeps programs and probes matching the running kernel
+// and renders templates in their code and cflags
+func prepareConfig(cfg config.Config, nodeID string) (config.Config, error) {
+	host, err := kernel.NewHost()
 	if err != nil {
-		log.Fatalf("Error listening on %s: %s", *listenAddress, err)
+		return cfg, err
+	}
+
+	selected, skipped, err := config.Select(cfg, host)
+	if err != nil {
+		return cfg, err
+	}
+
+	for _, reason := range skipped {
+		log.Printf("Kernel %s: %s", host.Release(), reason)
+	}
+
+	return config.RenderTemplates(selected, templateData(nodeID, host))
+}
+
+// templateData is the data for templates in code and cflags of programs
+func templateData(nodeID string, host *kernel.Host) config.TemplateData {
+	return config.TemplateData{
+		Env:    config.Environ(),
+		NodeID: nodeID,
+		Kernel: host.Release(),
 	}
 }
+
+// resolveExternalLabels merges labels of the kubernetes node, external labels
+// from the config and labels from flags, in the order of increasing priority
+func resolveExternalLabels(cfg config.Config, flagLabels map[string]string, nodeName string) (config.Config, error) {
+	labels := map[string]string{}
+
+	if len(cfg.Global.NodeLabels) > 0 {
+		nodeLabels, err := kube.NodeLabels(nodeName)
+		if err != nil {
+			return cfg, err
+		}
+
+		for name, nodeLabel := range cfg.Global.NodeLabels {
+			value, ok := nodeLabels[nodeLabel]
+			if !ok {
+				log.Printf("Node %q has no label %q for external label %q", nodeName, nodeLabel, name)
+				continue
+			}
+
+			labels[name] = value
+		}
+	}
+
+	for name, value := range cfg.Global.ExternalLabels {
+		labels[name] = value
+	}
+
+	for name, value := range flagLabels {
+		labels[name] = value
+	}
+
+	cfg.Global.ExternalLabels = labels
+
+	// Labels from flags are checked like ones from config files
+	if err := config.CheckExternalLabels(cfg); err != nil {
+		return cfg, err
+	}
+
+	return cfg, nil
+}
+
+// validate prints problems found in config files and returns exit code
+func validate(configFile string, configDir string) int {
+	paths := []string{configFile}
+
+	if configDir != "" {
+		var err error
+		paths, err = config.DirFiles(configDir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error listing config files: %s\n", err)
+			return 1
+		}
+	}
+
+	problems, err := config.ValidateFiles(paths, decoder.NewSet().Known)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading config: %s\n", err)
+		return 1
+	}
+
+	for _, problem := range problems {
+		fmt.Fprintf(os.Stderr, "%s\n", problem)
+	}
+
+	if len(problems) > 0 {
+		fmt.Fprintf(os.Stderr, "Found %d problems in config files %s\n", len(problems), strings.Join(paths, ", "))
+		return 1
+	}
+
+	fmt.Printf("No problems found in config files %s\n", strings.Join(paths, ", "))
+
+	return 0
+}
+
+// check prints problems found by compiling programs and returns exit code,
+// every variant of every program is compiled, whether its conditions match
+// the running kernel or not, so that variants for all kernels are checked
+func check(cfg config.Config, nodeID string) int {
+	host, err := kernel.NewHost()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading running kernel: %s\n", err)
+		return 1
+	}
+
+	rendered, err := config.RenderTemplates(cfg, templateData(nodeID, host))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error rendering templates: %s\n", err)
+		return 1
+	}
+
+	problems := exporter.Check(rendered)
+
+	for _, problem := range problems {
+		fmt.Fprintf(os.Stderr, "%s\n", problem)
+	}
+
+	if len(problems) > 0 {
+		fmt.Fprintf(os.Stderr, "Found %d problems in %d program variants\n", len(problems), len(rendered.Programs))
+		return 1
+	}
+
+	fmt.Printf("All %d program variants compiled and checked\n", len(rendered.Programs))
+
+	return 0
+}
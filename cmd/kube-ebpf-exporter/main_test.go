@@ -0,0 +1,47 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+func TestMetricsHandlerDefaultFormat(t *testing.T) {
+	app := kingpin.New("test", "")
+	enableOpenMetrics := enableOpenMetricsFlag(app)
+
+	if _, err := app.Parse([]string{}); err != nil {
+		t.Fatalf("Error parsing default flags: %s", err)
+	}
+
+	registry := prometheus.NewRegistry()
+
+	counter := prometheus.NewCounter(prometheus.CounterOpts{Name: "ebpf_exporter_sink_bytes", Help: "Bytes written"})
+	counter.Add(3)
+	registry.MustRegister(counter)
+
+	// Prometheus prefers OpenMetrics whenever the target offers it
+	r := httptest.NewRequest("GET", "/metrics", nil)
+	r.Header.Set("Accept", "application/openmetrics-text;version=0.0.1,text/plain;version=0.0.4;q=0.5,*/*;q=0.1")
+
+	w := httptest.NewRecorder()
+	metricsHandler(registry, registry, *enableOpenMetrics).ServeHTTP(w, r)
+
+	if contentType := w.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain; version=0.0.4") {
+		t.Errorf("Expected text format by default, got %q", contentType)
+	}
+
+	body, err := ioutil.ReadAll(w.Body)
+	if err != nil {
+		t.Fatalf("Error reading response: %s", err)
+	}
+
+	expected := "# HELP ebpf_exporter_sink_bytes Bytes written\n# TYPE ebpf_exporter_sink_bytes counter\nebpf_exporter_sink_bytes 3\n"
+	if !strings.HasPrefix(string(body), expected) {
+		t.Errorf("Expected text exposition to start with %q, got %q", expected, body)
+	}
+}
@@ -0,0 +1,214 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Condition limits a program or a set of probes to kernels it works on,
+// an empty condition matches every kernel
+type Condition struct {
+	KernelVersion string       `yaml:"kernel_version"`
+	Requires      Requirements `yaml:"requires"`
+}
+
+// Requirements are kernel features that must be present
+type Requirements struct {
+	// Symbols are kernel symbols from /proc/kallsyms, such as functions for kprobes
+	Symbols []string `yaml:"symbols"`
+	// Tracepoints are tracepoints in category:name form
+	Tracepoints []string `yaml:"tracepoints"`
+	// KernelConfig are kernel config options that are built in or modules
+	KernelConfig []string `yaml:"kernel_config"`
+}
+
+// ProbeSet is a set of probes attached only if its condition matches
+type ProbeSet struct {
+	Condition      `yaml:",inline"`
+	Kprobes        map[string]string `yaml:"kprobes"`
+	Kretprobes     map[string]string `yaml:"kretprobes"`
+	Tracepoints    map[string]string `yaml:"tracepoints"`
+	RawTracepoints map[string]string `yaml:"raw_tracepoints"`
+}
+
+// Kernel answers questions about the running kernel for conditions
+type Kernel interface {
+	// Release is the kernel release, like 5.4.0-100-generic
+	Release() string
+	HasSymbol(name string) (bool, error)
+	HasTracepoint(name string) (bool, error)
+	HasConfig(option string) (bool, error)
+}
+
+// Empty reports whether the condition matches every kernel
+func (c Condition) Empty() bool {
+	return c.KernelVersion == "" && len(c.Requires.Symbols) == 0 && len(c.Requires.Tracepoints) == 0 && len(c.Requires.KernelConfig) == 0
+}
+
+// Match checks the condition against the kernel, returning
+// the reason why it does not match if it does not
+func (c Condition) Match(kernel Kernel) (bool, string, error) {
+	if c.KernelVersion != "" {
+		constraints, err := ParseVersionConstraints(c.KernelVersion)
+		if err != nil {
+			return false, "", err
+		}
+
+		version, err := ParseKernelVersion(kernel.Release())
+		if err != nil {
+			return false, "", err
+		}
+
+		for _, constraint := range constraints {
+			if !constraint.Match(version) {
+				return false, fmt.Sprintf("kernel %s does not match %q", kernel.Release(), c.KernelVersion), nil
+			}
+		}
+	}
+
+	checks := []struct {
+		kind  string
+		names []string
+		has   func(string) (bool, error)
+	}{
+		{"symbol", c.Requires.Symbols, kernel.HasSymbol},
+		{"tracepoint", c.Requires.Tracepoints, kernel.HasTracepoint},
+		{"kernel config option", c.Requires.KernelConfig, kernel.HasConfig},
+	}
+
+	for _, check := range checks {
+		for _, name := range check.names {
+			ok, err := check.has(name)
+			if err != nil {
+				return false, "", fmt.Errorf("error looking up %s %q: %s", check.kind, name, err)
+			}
+
+			if !ok {
+				return false, fmt.Sprintf("%s %q is missing", check.kind, name), nil
+			}
+		}
+	}
+
+	return true, "", nil
+}
+
+// KernelVersion is a kernel version like 4.9.120
+type KernelVersion []int
+
+// String formats the version with dots
+func (v KernelVersion) String() string {
+	parts := make([]string, len(v))
+	for i, part := range v {
+		parts[i] = strconv.Itoa(part)
+	}
+
+	return strings.Join(parts, ".")
+}
+
+// ParseKernelVersion parses numeric parts of the kernel release,
+// 3.10.0-957.el7.x86_64 is parsed as 3.10.0
+func ParseKernelVersion(release string) (KernelVersion, error) {
+	end := strings.IndexFunc(release, func(r rune) bool {
+		return r != '.' && (r < '0' || r > '9')
+	})
+
+	if end != -1 {
+		release = release[:end]
+	}
+
+	version := KernelVersion{}
+
+	for _, part := range strings.Split(strings.TrimSuffix(release, "."), ".") {
+		number, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid kernel version %q", release)
+		}
+
+		version = append(version, number)
+	}
+
+	return version, nil
+}
+
+// VersionConstraint compares kernel versions with a version
+type VersionConstraint struct {
+	Op      string
+	Version KernelVersion
+}
+
+// versionOps are supported comparison operators, longer ones go first
+var versionOps = []string{">=", "<=", "==", "!=", ">", "<", "="}
+
+// ParseVersionConstraints parses comma separated constraints
+// like ">= 4.9, < 5.8", all of which must match
+func ParseVersionConstraints(constraints string) ([]VersionConstraint, error) {
+	parsed := []VersionConstraint{}
+
+	for _, constraint := range strings.Split(constraints, ",") {
+		constraint = strings.TrimSpace(constraint)
+
+		op := "=="
+		for _, candidate := range versionOps {
+			if strings.HasPrefix(constraint, candidate) {
+				op = candidate
+				constraint = strings.TrimSpace(strings.TrimPrefix(constraint, candidate))
+				break
+			}
+		}
+
+		if op == "=" {
+			op = "=="
+		}
+
+		if constraint == "" {
+			return nil, fmt.Errorf("invalid kernel version constraint %q: version is missing", constraints)
+		}
+
+		version, err := ParseKernelVersion(constraint)
+		if err != nil || version.String() != constraint {
+			return nil, fmt.Errorf("invalid kernel version constraint %q: %q is not a version", constraints, constraint)
+		}
+
+		parsed = append(parsed, VersionConstraint{Op: op, Version: version})
+	}
+
+	return parsed, nil
+}
+
+// Match compares as many parts of the version as the constraint has,
+// so that "== 4.9" matches 4.9.120 and "< 5.8" does not match 5.8.1
+func (c VersionConstraint) Match(version KernelVersion) bool {
+	cmp := 0
+
+	for i, part := range c.Version {
+		actual := 0
+		if i < len(version) {
+			actual = version[i]
+		}
+
+		if actual != part {
+			if actual < part {
+				cmp = -1
+			} else {
+				cmp = 1
+			}
+			break
+		}
+	}
+
+	switch c.Op {
+	case ">=":
+		return cmp >= 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case "<":
+		return cmp < 0
+	case "!=":
+		return cmp != 0
+	default:
+		return cmp == 0
+	}
+}
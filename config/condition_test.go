@@ -0,0 +1,166 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeKernel is a kernel with a fixed set of features
+type fakeKernel struct {
+	release  string
+	features map[string]bool
+}
+
+func (k fakeKernel) Release() string {
+	return k.release
+}
+
+func (k fakeKernel) HasSymbol(name string) (bool, error) {
+	return k.features[name], nil
+}
+
+func (k fakeKernel) HasTracepoint(name string) (bool, error) {
+	return k.features[name], nil
+}
+
+func (k fakeKernel) HasConfig(option string) (bool, error) {
+	return k.features[option], nil
+}
+
+func TestParseKernelVersion(t *testing.T) {
+	cases := []struct {
+		release  string
+		expected KernelVersion
+	}{
+		{"3.10.0-957.el7.x86_64", KernelVersion{3, 10, 0}},
+		{"5.4.0-100-generic", KernelVersion{5, 4, 0}},
+		{"4.19.91-21.al7.x86_64", KernelVersion{4, 19, 91}},
+		{"5.8", KernelVersion{5, 8}},
+	}
+
+	for _, c := range cases {
+		version, err := ParseKernelVersion(c.release)
+		if err != nil {
+			t.Errorf("Error parsing %q: %s", c.release, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(version, c.expected) {
+			t.Errorf("Expected %q to be parsed as %s, got %s", c.release, c.expected, version)
+		}
+	}
+
+	if _, err := ParseKernelVersion("generic"); err == nil {
+		t.Errorf("Expected error parsing release without version")
+	}
+}
+
+func TestVersionConstraints(t *testing.T) {
+	cases := []struct {
+		constraints string
+		release     string
+		expected    bool
+	}{
+		{">= 4.9, < 5.8", "4.9.120", true},
+		{">= 4.9, < 5.8", "5.7.19", true},
+		{">= 4.9, < 5.8", "5.8.1", false},
+		{">= 4.9, < 5.8", "3.10.0-957.el7.x86_64", false},
+		{"4.19", "4.19.91-21.al7.x86_64", true},
+		{"== 4.19", "4.19.91", true},
+		{"!= 3.10", "3.10.0", false},
+		{"> 4.9", "4.9.120", false},
+		{"> 4.9", "4.10.0", true},
+		{"<= 5.4.0", "5.4.0-100-generic", true},
+	}
+
+	for _, c := range cases {
+		constraints, err := ParseVersionConstraints(c.constraints)
+		if err != nil {
+			t.Errorf("Error parsing %q: %s", c.constraints, err)
+			continue
+		}
+
+		version, _ := ParseKernelVersion(c.release)
+
+		matched := true
+		for _, constraint := range constraints {
+			matched = matched && constraint.Match(version)
+		}
+
+		if matched != c.expected {
+			t.Errorf("Expected %q to match %s: %v, got %v", c.constraints, c.release, c.expected, matched)
+		}
+	}
+
+	for _, invalid := range []string{">=", ">= 4.x", ">= 4.9,", "~> 4.9"} {
+		if _, err := ParseVersionConstraints(invalid); err == nil {
+			t.Errorf("Expected error parsing %q", invalid)
+		}
+	}
+}
+
+func TestSelect(t *testing.T) {
+	config := Config{
+		Programs: []Program{
+			{
+				Name:      "bio",
+				Kprobes:   map[string]string{"blk_account_io_completion": "trace_req_completion"},
+				Condition: Condition{KernelVersion: "< 5.0"},
+			},
+			{
+				Name:      "bio",
+				Kprobes:   map[string]string{"blk_account_io_done": "trace_req_completion"},
+				Condition: Condition{KernelVersion: ">= 5.0"},
+				Probes: []ProbeSet{
+					{
+						Condition: Condition{Requires: Requirements{Symbols: []string{"blk_start_request"}}},
+						Kprobes:   map[string]string{"blk_start_request": "trace_req_start"},
+					},
+					{
+						Condition: Condition{Requires: Requirements{Symbols: []string{"blk_mq_start_request"}}},
+						Kprobes:   map[string]string{"blk_mq_start_request": "trace_req_start"},
+					},
+				},
+			},
+			{
+				Name:      "llcstat",
+				Condition: Condition{Requires: Requirements{KernelConfig: []string{"CONFIG_PERF_EVENTS"}}},
+			},
+		},
+	}
+
+	kernel := fakeKernel{release: "5.4.0-100-generic", features: map[string]bool{"blk_mq_start_request": true}}
+
+	selected, skipped, err := Select(config, kernel)
+	if err != nil {
+		t.Fatalf("Error selecting programs: %s", err)
+	}
+
+	if len(selected.Programs) != 1 {
+		t.Fatalf("Expected one program to be selected, got %#v", selected.Programs)
+	}
+
+	expected := map[string]string{
+		"blk_account_io_done":  "trace_req_completion",
+		"blk_mq_start_request": "trace_req_start",
+	}
+
+	if !reflect.DeepEqual(selected.Programs[0].Kprobes, expected) {
+		t.Errorf("Expected kprobes %v, got %v", expected, selected.Programs[0].Kprobes)
+	}
+
+	if len(config.Programs[1].Kprobes) != 1 {
+		t.Errorf("Expected original config to be unchanged, got kprobes %v", config.Programs[1].Kprobes)
+	}
+
+	if len(skipped) != 3 || !strings.Contains(skipped[2], `kernel config option "CONFIG_PERF_EVENTS" is missing`) {
+		t.Errorf("Unexpected skip reasons: %v", skipped)
+	}
+
+	config.Programs[0].Condition = Condition{}
+
+	if _, _, err := Select(config, kernel); err == nil {
+		t.Errorf("Expected error when more than one variant of a program matches")
+	}
+}
@@ -1,30 +1,177 @@
 package config
 
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+// Version is the latest version of the config format
+const Version = 1
+
+// DefaultNamespace prefixes names of metrics if namespace is not set
+const DefaultNamespace = "ebpf_exporter"
+
 // Config defines exporter configuration
 type Config struct {
-	Programs []Program `yaml:"programs"`
+	Version     int                `yaml:"version"`
+	Include     []string           `yaml:"include"`
+	Global      Global             `yaml:"global"`
+	Sink        Sink               `yaml:"sink"`
+	CloudEvents CloudEvents        `yaml:"cloudevents"`
+	LabelSets   map[string][]Label `yaml:"label_sets"`
+	Programs    []Program          `yaml:"programs"`
+}
+
+// Load decodes the config and expands environment variables and label sets,
+// keys that do not map to any field are errors
+func Load(r io.Reader) (Config, error) {
+	config, err := decode(r)
+	if err != nil {
+		return config, err
+	}
+
+	if err := expandLabelSets(&config); err != nil {
+		return config, err
+	}
+
+	return config, nil
+}
+
+// decode expands environment variables and decodes the config without
+// expanding label sets, which can be defined in other config files
+func decode(r io.Reader) (Config, error) {
+	config := Config{}
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return config, err
+	}
+
+	_, missing, err := decodeExpanded(data, &config)
+	if err != nil {
+		return config, err
+	}
+
+	if len(missing) > 0 {
+		return config, fmt.Errorf("line %d: environment variable %q is not set", missing[0].line, missing[0].name)
+	}
+
+	if err := checkVersion(config.Version); err != nil {
+		return config, err
+	}
+
+	return config, nil
+}
+
+// checkVersion checks that the config format version is supported,
+// configs without version are treated as the first version
+func checkVersion(version int) error {
+	if version < 0 || version > Version {
+		return fmt.Errorf("unsupported config version %d, the latest supported version is %d", version, Version)
+	}
+
+	return nil
+}
+
+// Global defines settings that apply to all programs
+type Global struct {
+	// Namespace prefixes names of all metrics, ebpf_exporter by default
+	Namespace string `yaml:"namespace"`
+	// ExternalLabels are added to every metric and sink record
+	ExternalLabels map[string]string `yaml:"external_labels"`
+	// NodeLabels map external labels to labels of the kubernetes node
+	// the exporter runs on, such as topology.kubernetes.io/zone
+	NodeLabels map[string]string `yaml:"node_labels"`
+}
+
+// MetricNamespace returns the namespace of metrics of the program
+func MetricNamespace(global Global, program Program) string {
+	if program.Namespace != "" {
+		return program.Namespace
+	}
+
+	if global.Namespace != "" {
+		return global.Namespace
+	}
+
+	return DefaultNamespace
 }
 
-// Program is an eBPF program with optional metrics attached to it
+// empty reports whether none of global settings are set
+func (g Global) empty() bool {
+	return g.Namespace == "" && len(g.ExternalLabels) == 0 && len(g.NodeLabels) == 0
+}
+
+// Sink defines where sink records are stored and how sink files are
+// rotated and cleaned up
+type Sink struct {
+	Root           string        `yaml:"root"`
+	RotateInterval time.Duration `yaml:"rotate_interval"`
+	RotateSize     int64         `yaml:"rotate_size"`
+	MaxAge         time.Duration `yaml:"max_age"`
+	MaxTotalBytes  int64         `yaml:"max_total_bytes"`
+	FsyncOnRotate  bool          `yaml:"fsync_on_rotate"`
+	FlushInterval  time.Duration `yaml:"flush_interval"`
+	// CompressionLevel is a gzip level from -2 to 9, where 0 is
+	// no compression, gzip default compression is used if not set
+	CompressionLevel *int          `yaml:"compression_level"`
+	ValueMode        SinkValueMode `yaml:"value_mode"`
+	QueueSize        int           `yaml:"queue_size"`
+	OverflowPolicy   SinkOverflow  `yaml:"overflow_policy"`
+	BlockTimeout     time.Duration `yaml:"block_timeout"`
+}
+
+// CloudEvents defines where lifecycle events are delivered
+type CloudEvents struct {
+	Source       string        `yaml:"source"`
+	File         string        `yaml:"file"`
+	HTTPEndpoint string        `yaml:"http_endpoint"`
+	HTTPTimeout  time.Duration `yaml:"http_timeout"`
+}
+
+// Program is an eBPF program with optional metrics attached to it,
+// programs with conditions are only loaded on kernels they match,
+// namespace overrides the global namespace for metrics of the program
 type Program struct {
 	Name           string            `yaml:"name"`
+	Namespace      string            `yaml:"namespace"`
 	Metrics        Metrics           `yaml:"metrics"`
 	Kprobes        map[string]string `yaml:"kprobes"`
 	Kretprobes     map[string]string `yaml:"kretprobes"`
 	Tracepoints    map[string]string `yaml:"tracepoints"`
 	RawTracepoints map[string]string `yaml:"raw_tracepoints"`
+	Probes         []ProbeSet        `yaml:"probes"`
 	PerfEvents     []PerfEvent       `yaml:"perf_events"`
+	Events         []Event           `yaml:"events"`
 	Code           string            `yaml:"code"`
+	CodeFile       string            `yaml:"code_file"`
 	Cflags         []string          `yaml:"cflags"`
+	Templates      bool              `yaml:"templates"`
+	Condition      `yaml:",inline"`
 }
 
-// PerfEvent describes perf_event to attach to
+// PerfEvent describes perf_event to attach to, by default on all cpus
+// without filtering by cgroup
 type PerfEvent struct {
-	Type            int    `yaml:"string"`
-	Name            int    `yaml:"name"`
-	Target          string `yaml:"target"`
-	SamplePeriod    int    `yaml:"sample_period"`
-	SampleFrequency int    `yaml:"sample_frequency"`
+	Type            PerfEventType `yaml:"type"`
+	Name            PerfEventName `yaml:"name"`
+	Target          string        `yaml:"target"`
+	SamplePeriod    int           `yaml:"sample_period"`
+	SampleFrequency int           `yaml:"sample_frequency"`
+	CPUs            []int         `yaml:"cpus"`
+	Cgroup          string        `yaml:"cgroup"`
+}
+
+// Event is a stream of records sent from eBPF program via BPF_PERF_OUTPUT
+// table, where each record is decoded with labels like a table key
+type Event struct {
+	Name   string  `yaml:"name"`
+	Table  string  `yaml:"table"`
+	Labels []Label `yaml:"labels"`
+	Sink   bool    `yaml:"sink"`
+	Stream bool    `yaml:"stream"`
 }
 
 // Metrics is a collection of metrics attached to a program
@@ -35,11 +182,15 @@ type Metrics struct {
 
 // Counter is a metric defining prometheus counter
 type Counter struct {
-	Name     string  `yaml:"name"`
-	Help     string  `yaml:"help"`
-	Table    string  `yaml:"table"`
-	Labels   []Label `yaml:"labels"`
-	SinkMode int     `yaml:"sink_mode"`
+	Name       string  `yaml:"name"`
+	Help       string  `yaml:"help"`
+	Table      string  `yaml:"table"`
+	Event      string  `yaml:"event"`
+	MaxSeries  int     `yaml:"max_series"`
+	LabelsFrom string  `yaml:"labels_from"`
+	Labels     []Label `yaml:"labels"`
+	SinkMode   int     `yaml:"sink_mode"`
+	NoNodeID   bool    `yaml:"no_node_id"`
 }
 
 // Histogram is a metric defining prometheus histogram
@@ -47,15 +198,67 @@ type Histogram struct {
 	Name             string              `yaml:"name"`
 	Help             string              `yaml:"help"`
 	Table            string              `yaml:"table"`
+	Event            string              `yaml:"event"`
+	MaxSeries        int                 `yaml:"max_series"`
 	BucketType       HistogramBucketType `yaml:"bucket_type"`
 	BucketMultiplier float64             `yaml:"bucket_multiplier"`
 	BucketMin        int                 `yaml:"bucket_min"`
 	BucketMax        int                 `yaml:"bucket_max"`
-	Labels           []Label             `yaml:"labels"`
+	// BucketSubBuckets is the number of buckets every power of two is split
+	// into with log-linear buckets, it must be a power of two itself
+	BucketSubBuckets int `yaml:"bucket_sub_buckets"`
+	// BucketBoundaries are upper limits of custom buckets, the key is
+	// the index of the first boundary that is not below the value
+	BucketBoundaries []float64 `yaml:"bucket_boundaries"`
+	// UnderflowSlot is the slot with values below the first bucket,
+	// which are counted in every bucket
+	UnderflowSlot *int `yaml:"underflow_slot"`
+	// OverflowSlot is the slot with values above the last bucket,
+	// which are only counted in the +Inf bucket
+	OverflowSlot *int `yaml:"overflow_slot"`
+	// SumSlot is the slot with the sum of values, bucket_max + 1 if not set
+	SumSlot    *int    `yaml:"sum_slot"`
+	LabelsFrom string  `yaml:"labels_from"`
+	Labels     []Label `yaml:"labels"`
+	NoNodeID   bool    `yaml:"no_node_id"`
+	// Native exports exp2 histograms as native histograms with exponential
+	// buckets of schema 0 instead of classic buckets
+	Native bool `yaml:"native"`
+	// Quantiles are estimated from buckets and exported as gauges
+	// with quantile label next to the histogram
+	Quantiles []float64 `yaml:"quantiles"`
+	// NoBuckets only exports quantiles of the histogram without buckets
+	NoBuckets bool `yaml:"no_buckets"`
+	// Exemplars attaches who put values into buckets to buckets
+	Exemplars Exemplars `yaml:"exemplars"`
+}
+
+// Exemplars defines a table with the same keys as the histogram table,
+// where eBPF programs record the last pid or cgroup id of every bucket,
+// values of the table are decoded with labels into exemplars of buckets
+type Exemplars struct {
+	Table      string  `yaml:"table"`
+	LabelsFrom string  `yaml:"labels_from"`
+	Labels     []Label `yaml:"labels"`
+}
+
+// QuantileName returns the name of the metric with quantiles of the histogram
+func (h Histogram) QuantileName() string {
+	return h.Name + "_quantile"
+}
+
+// SumKey returns the slot with the sum of values of the histogram
+func (h Histogram) SumKey() int {
+	if h.SumSlot != nil {
+		return *h.SumSlot
+	}
+
+	return h.BucketMax + 1
 }
 
 // Label defines how to decode an element from eBPF table key
-// with the list of decoders
+// with the list of decoders, labels of label sets go before
+// labels of metrics that use them with labels_from
 type Label struct {
 	Name     string    `yaml:"name"`
 	Size     uint      `yaml:"size"`
@@ -78,4 +281,33 @@ const (
 	HistogramBucketExp2 = "exp2"
 	// HistogramBucketLinear means histogram with linear keys
 	HistogramBucketLinear = "linear"
+	// HistogramBucketLogLinear means histograms with power-of-two ranges
+	// split into bucket_sub_buckets linear buckets each
+	HistogramBucketLogLinear = "log-linear"
+	// HistogramBucketCustom means histograms with keys being indexes
+	// into the list of bucket_boundaries
+	HistogramBucketCustom = "custom"
+)
+
+// SinkValueMode is an enum to define which values go into sink records
+type SinkValueMode string
+
+const (
+	// SinkValueCumulative means sink records carry raw map values
+	SinkValueCumulative = "cumulative"
+	// SinkValueDelta means sink records carry the change since the previous collection
+	SinkValueDelta = "delta"
+)
+
+// SinkOverflow is an enum to define what happens when the sink queue is full
+type SinkOverflow string
+
+const (
+	// SinkOverflowDropOldest means the oldest queued batch is dropped
+	SinkOverflowDropOldest = "drop-oldest"
+	// SinkOverflowDropNewest means the batch that does not fit is dropped
+	SinkOverflowDropNewest = "drop-newest"
+	// SinkOverflowBlock means collection waits up to block_timeout for
+	// the queue to have space and then drops the batch
+	SinkOverflowBlock = "block"
 )
@@ -0,0 +1,56 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	cases := []struct {
+		in  string
+		err bool
+	}{
+		{
+			in:  "programs:\n  - name: timers\n",
+			err: false,
+		},
+		{
+			in:  "version: 1\nprograms:\n  - name: timers\n",
+			err: false,
+		},
+		{
+			in:  "",
+			err: false,
+		},
+		{
+			in:  "version: 2\n",
+			err: true,
+		},
+		{
+			in:  "programs:\n  - name: timers\n    metrics:\n      counters:\n        - name: timer_start_total\n          sink_mod: 1\n",
+			err: true,
+		},
+	}
+
+	for _, c := range cases {
+		_, err := Load(strings.NewReader(c.in))
+		if c.err && err == nil {
+			t.Errorf("Expected error loading %q", c.in)
+		}
+
+		if !c.err && err != nil {
+			t.Errorf("Unexpected error loading %q: %s", c.in, err)
+		}
+	}
+}
+
+func TestLoadPerfEventType(t *testing.T) {
+	config, err := Load(strings.NewReader("programs:\n  - name: llcstat\n    perf_events:\n      - type: 3\n        name: 1\n"))
+	if err != nil {
+		t.Fatalf("Error loading config: %s", err)
+	}
+
+	if perfEvent := config.Programs[0].PerfEvents[0]; perfEvent.Type != "3" || perfEvent.Name != "1" {
+		t.Errorf("Expected perf event 3:1, got %s:%s", perfEvent.Type, perfEvent.Name)
+	}
+}
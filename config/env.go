@@ -0,0 +1,162 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// envReference matches ${NAME} and ${NAME:-default}, $${ is an escaped ${
+var envReference = regexp.MustCompile(`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// envMissing is an environment variable without a value or a default
+type envMissing struct {
+	name string
+	line int
+}
+
+// expandEnv replaces references to environment variables in the text,
+// references to variables that are not set and have no default are
+// left as they are and returned as missing
+func expandEnv(data []byte, lookup func(string) (string, bool)) ([]byte, []envMissing) {
+	missing := []envMissing{}
+
+	expanded := []byte{}
+	last := 0
+
+	for _, match := range envReference.FindAllSubmatchIndex(data, -1) {
+		expanded = append(expanded, data[last:match[0]]...)
+		last = match[1]
+
+		reference := data[match[0]:match[1]]
+
+		if bytes.HasPrefix(reference, []byte("$$")) {
+			expanded = append(expanded, reference[1:]...)
+			continue
+		}
+
+		name := string(data[match[2]:match[3]])
+
+		if value, ok := lookup(name); ok {
+			expanded = append(expanded, value...)
+			continue
+		}
+
+		if match[4] != -1 {
+			expanded = append(expanded, data[match[6]:match[7]]...)
+			continue
+		}
+
+		missing = append(missing, envMissing{name: name, line: bytes.Count(data[:match[0]], []byte("\n")) + 1})
+		expanded = append(expanded, reference...)
+	}
+
+	return append(expanded, data[last:]...), missing
+}
+
+// expandEnvNode replaces references to environment variables in scalar
+// values of the parsed config. Keys and comments are left as they are and
+// values never change the structure of the config, whatever they contain.
+func expandEnvNode(node *yaml.Node, lookup func(string) (string, bool)) []envMissing {
+	missing := []envMissing{}
+
+	switch node.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, child := range node.Content {
+			missing = append(missing, expandEnvNode(child, lookup)...)
+		}
+	case yaml.MappingNode:
+		for i := 1; i < len(node.Content); i += 2 {
+			missing = append(missing, expandEnvNode(node.Content[i], lookup)...)
+		}
+	case yaml.ScalarNode:
+		expanded, unset := expandEnv([]byte(node.Value), lookup)
+
+		// Text of block scalars starts on the line after the indicator
+		first := node.Line
+		if node.Style&(yaml.LiteralStyle|yaml.FoldedStyle) != 0 {
+			first++
+		}
+
+		for _, env := range unset {
+			missing = append(missing, envMissing{name: env.name, line: first + env.line - 1})
+		}
+
+		if string(expanded) != node.Value {
+			node.Value = string(expanded)
+
+			// Plain values are resolved again, so that numbers stay numbers
+			if node.Style == 0 {
+				node.Tag = ""
+			}
+		}
+	}
+
+	return missing
+}
+
+// decodeExpanded parses the config, expands environment variables in its
+// values and decodes it. Unknown fields are found by strictly decoding the
+// original data, which has the same keys. Parsed nodes are returned with
+// lines of the original data to find lines of problems.
+func decodeExpanded(data []byte, config *Config) (*yaml.Node, []envMissing, error) {
+	root := &yaml.Node{}
+	if err := yaml.Unmarshal(data, root); err != nil {
+		return root, nil, err
+	}
+
+	if root.Kind == 0 {
+		return root, nil, nil
+	}
+
+	missing := expandEnvNode(root, os.LookupEnv)
+
+	errors := []string{}
+
+	if err := root.Decode(config); err != nil {
+		typeErr, ok := err.(*yaml.TypeError)
+		if !ok {
+			return root, missing, err
+		}
+
+		errors = append(errors, typeErr.Errors...)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+
+	if err := decoder.Decode(&Config{}); err != nil {
+		if typeErr, ok := err.(*yaml.TypeError); ok {
+			for _, message := range typeErr.Errors {
+				if strings.Contains(message, " not found in type ") {
+					errors = append(errors, message)
+				}
+			}
+		}
+	}
+
+	if len(errors) == 0 {
+		return root, missing, nil
+	}
+
+	sort.SliceStable(errors, func(i, j int) bool {
+		return typeErrorLineNumber(errors[i]) < typeErrorLineNumber(errors[j])
+	})
+
+	return root, missing, &yaml.TypeError{Errors: errors}
+}
+
+// typeErrorLineNumber returns the line of the yaml type error message
+func typeErrorLineNumber(message string) int {
+	if match := typeErrorLine.FindStringSubmatch(message); match != nil {
+		line, _ := strconv.Atoi(match[1])
+		return line
+	}
+
+	return 0
+}
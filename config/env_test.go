@@ -0,0 +1,118 @@
+package config
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExpandEnv(t *testing.T) {
+	env := map[string]string{"SINK_ROOT": "/data", "EMPTY": ""}
+
+	lookup := func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+
+	in := "root: ${SINK_ROOT}/sink\nempty: '${EMPTY:-unused}'\nsize: ${QUEUE_SIZE:-64}\nliteral: $${SINK_ROOT}\nmissing: ${MISSING}\nplain: $SINK_ROOT\n"
+
+	expanded, missing := expandEnv([]byte(in), lookup)
+
+	expected := "root: /data/sink\nempty: ''\nsize: 64\nliteral: ${SINK_ROOT}\nmissing: ${MISSING}\nplain: $SINK_ROOT\n"
+	if string(expanded) != expected {
+		t.Errorf("Expected %q, got %q", expected, expanded)
+	}
+
+	if !reflect.DeepEqual(missing, []envMissing{{name: "MISSING", line: 5}}) {
+		t.Errorf("Expected MISSING on line 5 to be missing, got %v", missing)
+	}
+}
+
+func TestLoadEnv(t *testing.T) {
+	os.Setenv("TEST_EXPORTER_SINK_ROOT", "/data")
+	defer os.Unsetenv("TEST_EXPORTER_SINK_ROOT")
+
+	config, err := Load(strings.NewReader("sink:\n  root: ${TEST_EXPORTER_SINK_ROOT}\n  queue_size: ${TEST_EXPORTER_QUEUE_SIZE:-64}\n"))
+	if err != nil {
+		t.Fatalf("Error loading config: %s", err)
+	}
+
+	if config.Sink.Root != "/data" || config.Sink.QueueSize != 64 {
+		t.Errorf("Expected sink root /data and queue size 64, got %q and %d", config.Sink.Root, config.Sink.QueueSize)
+	}
+
+	if _, err := Load(strings.NewReader("sink:\n  root: ${TEST_EXPORTER_MISSING}\n")); err == nil {
+		t.Errorf("Expected error loading config with unset environment variable")
+	}
+}
+
+func TestLoadEnvValuesOnly(t *testing.T) {
+	os.Setenv("TEST_EXPORTER_SINK_ROOT", "/data: #1")
+	defer os.Unsetenv("TEST_EXPORTER_SINK_ROOT")
+
+	data := "# ${TEST_EXPORTER_MISSING} is only mentioned in a comment\nsink:\n  root: ${TEST_EXPORTER_SINK_ROOT}\n  queue_size: ${TEST_EXPORTER_QUEUE_SIZE:-64} # ${TEST_EXPORTER_MISSING}\n"
+
+	config, err := Load(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("Error loading config: %s", err)
+	}
+
+	if config.Sink.Root != "/data: #1" || config.Sink.QueueSize != 64 {
+		t.Errorf("Expected sink root %q and queue size 64, got %q and %d", "/data: #1", config.Sink.Root, config.Sink.QueueSize)
+	}
+
+	_, err = Load(strings.NewReader("programs:\n  - name: bio\n    code: |\n      // ok\n      // ${TEST_EXPORTER_MISSING}\n"))
+	if err == nil || !strings.HasPrefix(err.Error(), "line 5:") {
+		t.Errorf("Expected error about unset variable on line 5, got %v", err)
+	}
+}
+
+func TestRenderTemplates(t *testing.T) {
+	os.Setenv("TEST_EXPORTER_TARGET_PID", "42")
+	defer os.Unsetenv("TEST_EXPORTER_TARGET_PID")
+
+	config := Config{
+		Programs: []Program{
+			{
+				Name:      "bio",
+				Code:      "#define MAX_DISKS {{.Env.MAX_DISKS}}\n// {{.Program}} on {{.NodeID}} with {{.Kernel}}\n",
+				Cflags:    []string{"-DTARGET_PID={{env \"TEST_EXPORTER_TARGET_PID\"}}", "-DSLOTS={{env \"TEST_EXPORTER_SLOTS\" \"27\"}}", "-I/usr/include"},
+				Templates: true,
+			},
+			{
+				Name: "plain",
+				Code: "struct key k = {{0}};\n",
+			},
+		},
+	}
+
+	data := TemplateData{Env: map[string]string{"MAX_DISKS": "16"}, NodeID: "node-1", Kernel: "5.4.0"}
+
+	rendered, err := RenderTemplates(config, data)
+	if err != nil {
+		t.Fatalf("Error rendering templates: %s", err)
+	}
+
+	if code := rendered.Programs[0].Code; code != "#define MAX_DISKS 16\n// bio on node-1 with 5.4.0\n" {
+		t.Errorf("Unexpected rendered code %q", code)
+	}
+
+	if cflags := rendered.Programs[0].Cflags; !reflect.DeepEqual(cflags, []string{"-DTARGET_PID=42", "-DSLOTS=27", "-I/usr/include"}) {
+		t.Errorf("Unexpected rendered cflags %v", cflags)
+	}
+
+	if code := rendered.Programs[1].Code; code != "struct key k = {{0}};\n" {
+		t.Errorf("Expected code of program without templates to be left as is, got %q", code)
+	}
+
+	if config.Programs[0].Cflags[0] != "-DTARGET_PID={{env \"TEST_EXPORTER_TARGET_PID\"}}" {
+		t.Errorf("Expected original config to be unchanged, got cflags %v", config.Programs[0].Cflags)
+	}
+
+	data.Env = map[string]string{}
+
+	if _, err := RenderTemplates(config, data); err == nil {
+		t.Errorf("Expected error rendering template with unset environment variable")
+	}
+}
@@ -0,0 +1,147 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// cloudEventsAttributes are context attributes of CloudEvents 1.0 along
+// with the data, extensions made of external labels must not replace them
+var cloudEventsAttributes = map[string]bool{
+	"specversion":     true,
+	"id":              true,
+	"source":          true,
+	"type":            true,
+	"datacontenttype": true,
+	"dataschema":      true,
+	"subject":         true,
+	"time":            true,
+	"data":            true,
+	"database64":      true,
+}
+
+// reservedLabels are labels set by the exporter itself, on program metrics
+// and on metrics about the exporter, external labels must not replace them
+var reservedLabels = map[string]bool{
+	"node_id":  true,
+	"name":     true,
+	"program":  true,
+	"function": true,
+	"tag":      true,
+	"event":    true,
+	"metric":   true,
+}
+
+// ExtensionName turns an external label name into the name of the
+// CloudEvents extension attribute carrying the label in events,
+// which can only consist of lower-case letters and digits
+func ExtensionName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		default:
+			return -1
+		}
+	}, name)
+}
+
+// checkExternalLabel checks that the name can be used for an external label,
+// which is added to metrics and becomes an extension attribute of events
+func checkExternalLabel(name string) error {
+	extension := ExtensionName(name)
+
+	switch {
+	case reservedLabels[name]:
+		return fmt.Errorf("external label %q is reserved", name)
+	case !labelName.MatchString(name) || strings.HasPrefix(name, "__") || extension == "":
+		return fmt.Errorf("external label %q is not a valid label name", name)
+	case cloudEventsAttributes[extension]:
+		return fmt.Errorf("external label %q would replace CloudEvents attribute %q", name, extension)
+	}
+
+	return nil
+}
+
+// extensionOwners tracks which external label became which extension
+type extensionOwners map[string]string
+
+// check returns an error if another external label became the same extension
+func (o extensionOwners) check(name string) error {
+	extension := ExtensionName(name)
+
+	if owner, ok := o[extension]; ok && owner != name {
+		return fmt.Errorf("external labels %q and %q are both CloudEvents extension %q", owner, name, extension)
+	}
+
+	o[extension] = name
+
+	return nil
+}
+
+// CheckExternalLabels checks the external labels of a loaded config,
+// including labels that were added after loading, the same way
+// Validate checks external labels in config files
+func CheckExternalLabels(config Config) error {
+	names := []string{}
+	for name := range config.Global.ExternalLabels {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	owners := extensionOwners{}
+
+	for _, name := range names {
+		if err := checkExternalLabel(name); err != nil {
+			return err
+		}
+
+		if err := owners.check(name); err != nil {
+			return err
+		}
+	}
+
+	for _, program := range config.Programs {
+		for _, counter := range program.Metrics.Counters {
+			if err := checkMetricLabels(config.Global, program.Name, counter.Name, counter.Labels); err != nil {
+				return err
+			}
+		}
+
+		for _, histogram := range program.Metrics.Histograms {
+			// The last label of histograms is the bucket
+			if len(histogram.Labels) == 0 {
+				continue
+			}
+
+			if err := checkMetricLabels(config.Global, program.Name, histogram.Name, histogram.Labels[:len(histogram.Labels)-1]); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkMetricLabels returns an error if a label of the metric is also an external label
+func checkMetricLabels(global Global, program string, metric string, labels []Label) error {
+	for _, label := range labels {
+		if isExternalLabel(global, label.Name) {
+			return fmt.Errorf("program %q: label %q of metric %q is also an external label", program, label.Name, metric)
+		}
+	}
+
+	return nil
+}
+
+// isExternalLabel reports whether every metric gets the label from global settings
+func isExternalLabel(global Global, name string) bool {
+	_, static := global.ExternalLabels[name]
+	_, node := global.NodeLabels[name]
+
+	return static || node || name == "node_id"
+}
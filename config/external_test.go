@@ -0,0 +1,58 @@
+package config
+
+import "testing"
+
+func TestExtensionName(t *testing.T) {
+	cases := map[string]string{
+		"zone":         "zone",
+		"k8s_cluster":  "k8scluster",
+		"Availability": "availability",
+	}
+
+	for in, expected := range cases {
+		if got := ExtensionName(in); got != expected {
+			t.Errorf("Expected extension name %q for %q, got %q", expected, in, got)
+		}
+	}
+}
+
+func TestCheckExternalLabels(t *testing.T) {
+	programs := []Program{
+		{
+			Name: "timers",
+			Metrics: Metrics{
+				Counters: []Counter{{Name: "timer_start_total", Labels: []Label{{Name: "device"}}}},
+			},
+		},
+	}
+
+	cases := []struct {
+		labels   map[string]string
+		expected string
+	}{
+		{labels: map[string]string{"zone": "a", "k8s_cluster": "prod"}},
+		{labels: map[string]string{"node_id": "node-1"}, expected: `external label "node_id" is reserved`},
+		{labels: map[string]string{"__zone": "a"}, expected: `external label "__zone" is not a valid label name`},
+		{labels: map[string]string{"zone-a": "a"}, expected: `external label "zone-a" is not a valid label name`},
+		{labels: map[string]string{"source": "a"}, expected: `external label "source" would replace CloudEvents attribute "source"`},
+		{labels: map[string]string{"k8s_cluster": "a", "k8scluster": "b"}, expected: `external labels "k8s_cluster" and "k8scluster" are both CloudEvents extension "k8scluster"`},
+		{labels: map[string]string{"program": "a"}, expected: `external label "program" is reserved`},
+		{labels: map[string]string{"device": "a"}, expected: `program "timers": label "device" of metric "timer_start_total" is also an external label`},
+	}
+
+	for _, c := range cases {
+		err := CheckExternalLabels(Config{Global: Global{ExternalLabels: c.labels}, Programs: programs})
+
+		if c.expected == "" {
+			if err != nil {
+				t.Errorf("Expected no error for %v, got %s", c.labels, err)
+			}
+
+			continue
+		}
+
+		if err == nil || err.Error() != c.expected {
+			t.Errorf("Expected error %q for %v, got %v", c.expected, c.labels, err)
+		}
+	}
+}
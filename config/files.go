@@ -0,0 +1,224 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// LoadFile loads the config file along with files it includes
+// and code files of its programs
+func LoadFile(path string) (Config, error) {
+	config := Config{}
+
+	l := &loader{seen: map[string]bool{}}
+	if err := l.load(&config, path); err != nil {
+		return config, err
+	}
+
+	if err := expandLabelSets(&config); err != nil {
+		return config, err
+	}
+
+	return config, nil
+}
+
+// LoadDir loads every config file in the directory as one config
+func LoadDir(dir string) (Config, error) {
+	config := Config{}
+
+	paths, err := DirFiles(dir)
+	if err != nil {
+		return config, err
+	}
+
+	l := &loader{seen: map[string]bool{}}
+	for _, path := range paths {
+		if err := l.load(&config, path); err != nil {
+			return config, err
+		}
+	}
+
+	if err := expandLabelSets(&config); err != nil {
+		return config, err
+	}
+
+	return config, nil
+}
+
+// DirFiles returns yaml files in the directory sorted by name
+func DirFiles(dir string) ([]string, error) {
+	paths := []string{}
+
+	for _, pattern := range []string{"*.yaml", "*.yml"} {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return nil, err
+		}
+
+		paths = append(paths, matches...)
+	}
+
+	if len(paths) == 0 {
+		if _, err := os.Stat(dir); err != nil {
+			return nil, err
+		}
+
+		return nil, fmt.Errorf("no config files found in %q", dir)
+	}
+
+	sort.Strings(paths)
+
+	return paths, nil
+}
+
+// includeFiles returns files matching the include pattern,
+// relative patterns are relative to the including file
+func includeFiles(dir string, pattern string) ([]string, error) {
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(dir, pattern)
+	}
+
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	// Patterns without wildcards name files that must exist
+	if len(matches) == 0 && !strings.ContainsAny(pattern, "*?[") {
+		return nil, fmt.Errorf("included file %q is not found", pattern)
+	}
+
+	return matches, nil
+}
+
+// loader loads config files and keeps track of loaded ones
+type loader struct {
+	seen map[string]bool
+}
+
+// load loads the config file and files it includes into dst
+func (l *loader) load(dst *Config, path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	if l.seen[abs] {
+		return fmt.Errorf("config file %q is loaded more than once", path)
+	}
+
+	l.seen[abs] = true
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	config, err := decode(file)
+	if err != nil {
+		return fmt.Errorf("error reading config file %q: %s", path, err)
+	}
+
+	dir := filepath.Dir(path)
+
+	for i := range config.Programs {
+		if err := loadCode(&config.Programs[i], dir); err != nil {
+			return fmt.Errorf("error reading config file %q: %s", path, err)
+		}
+	}
+
+	if err := merge(dst, config); err != nil {
+		return fmt.Errorf("error reading config file %q: %s", path, err)
+	}
+
+	for _, pattern := range config.Include {
+		paths, err := includeFiles(dir, pattern)
+		if err != nil {
+			return fmt.Errorf("error reading config file %q: %s", path, err)
+		}
+
+		for _, included := range paths {
+			if err := l.load(dst, included); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// loadCode reads code of the program from code_file relative to dir
+func loadCode(program *Program, dir string) error {
+	if program.CodeFile == "" {
+		return nil
+	}
+
+	if program.Code != "" {
+		return fmt.Errorf("program %q has both code and code_file", program.Name)
+	}
+
+	path := program.CodeFile
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+
+	code, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading code of program %q: %s", program.Name, err)
+	}
+
+	program.Code = string(code)
+	program.CodeFile = path
+
+	return nil
+}
+
+// merge adds programs and label sets of the config to dst, other settings
+// can only be set in one of the merged configs
+func merge(dst *Config, config Config) error {
+	if config.Sink != (Sink{}) {
+		if dst.Sink != (Sink{}) {
+			return fmt.Errorf("sink is already set in another config file")
+		}
+
+		dst.Sink = config.Sink
+	}
+
+	if !config.Global.empty() {
+		if !dst.Global.empty() {
+			return fmt.Errorf("global is already set in another config file")
+		}
+
+		dst.Global = config.Global
+	}
+
+	if config.CloudEvents != (CloudEvents{}) {
+		if dst.CloudEvents != (CloudEvents{}) {
+			return fmt.Errorf("cloudevents is already set in another config file")
+		}
+
+		dst.CloudEvents = config.CloudEvents
+	}
+
+	for name, labels := range config.LabelSets {
+		if _, ok := dst.LabelSets[name]; ok {
+			return fmt.Errorf("label set %q is already defined in another config file", name)
+		}
+
+		if dst.LabelSets == nil {
+			dst.LabelSets = map[string][]Label{}
+		}
+
+		dst.LabelSets[name] = labels
+	}
+
+	dst.Programs = append(dst.Programs, config.Programs...)
+
+	return nil
+}
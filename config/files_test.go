@@ -0,0 +1,140 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeFiles creates files with provided contents in a temporary directory
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatalf("Error creating temporary directory: %s", err)
+	}
+
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Error creating directory for %q: %s", name, err)
+		}
+
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatalf("Error writing %q: %s", name, err)
+		}
+	}
+
+	return dir
+}
+
+func TestLoadFileIncludes(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"config.yaml":            "version: 1\ninclude:\n  - programs/*.yaml\nsink:\n  root: /tmp/sink\nprograms:\n  - name: timers\n    code: int timers;\n",
+		"programs/bio.yaml":      "programs:\n  - name: bio\n    code_file: bio.c\n",
+		"programs/bio.c":         "int bio;\n",
+		"programs/cachestat.yml": "programs:\n  - name: cachestat\n",
+	})
+	defer os.RemoveAll(dir)
+
+	config, err := LoadFile(filepath.Join(dir, "config.yaml"))
+	if err != nil {
+		t.Fatalf("Error loading config: %s", err)
+	}
+
+	names := []string{}
+	for _, program := range config.Programs {
+		names = append(names, program.Name)
+	}
+
+	if !reflect.DeepEqual(names, []string{"timers", "bio"}) {
+		t.Errorf("Expected programs [timers bio], got %v", names)
+	}
+
+	if config.Programs[1].Code != "int bio;\n" || config.Programs[1].CodeFile != filepath.Join(dir, "programs", "bio.c") {
+		t.Errorf("Expected code of bio to be loaded from bio.c, got %q from %q", config.Programs[1].Code, config.Programs[1].CodeFile)
+	}
+
+	if config.Sink.Root != "/tmp/sink" {
+		t.Errorf("Expected sink root /tmp/sink, got %q", config.Sink.Root)
+	}
+}
+
+func TestLoadDir(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"b.yaml":     "programs:\n  - name: bio\n",
+		"a.yml":      "sink:\n  root: /tmp/sink\nprograms:\n  - name: accept\n",
+		"README.txt": "not a config",
+	})
+	defer os.RemoveAll(dir)
+
+	config, err := LoadDir(dir)
+	if err != nil {
+		t.Fatalf("Error loading config: %s", err)
+	}
+
+	if len(config.Programs) != 2 || config.Programs[0].Name != "accept" || config.Programs[1].Name != "bio" {
+		t.Errorf("Expected programs accept and bio, got %#v", config.Programs)
+	}
+}
+
+func TestLoadFileErrors(t *testing.T) {
+	cases := []map[string]string{
+		{
+			"config.yaml": "include: [other.yaml]\n",
+			"other.yaml":  "include: [config.yaml]\n",
+		},
+		{
+			"config.yaml": "include: [missing.yaml]\n",
+		},
+		{
+			"config.yaml": "sink:\n  root: /a\ninclude: [other.yaml]\n",
+			"other.yaml":  "sink:\n  root: /b\n",
+		},
+		{
+			"config.yaml": "programs:\n  - name: bio\n    code: int bio;\n    code_file: bio.c\n",
+			"bio.c":       "int bio;\n",
+		},
+		{
+			"config.yaml": "programs:\n  - name: bio\n    code_file: missing.c\n",
+		},
+	}
+
+	for i, files := range cases {
+		dir := writeFiles(t, files)
+
+		if _, err := LoadFile(filepath.Join(dir, "config.yaml")); err == nil {
+			t.Errorf("Expected error loading config in case %d", i)
+		}
+
+		os.RemoveAll(dir)
+	}
+}
+
+func TestValidateFiles(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"config.yaml": "include:\n  - bio.yaml\n  - missing.yaml\nprograms:\n  - name: bio\n",
+		"bio.yaml":    "programs:\n  - name: bio\n    code_file: missing.c\n",
+	})
+	defer os.RemoveAll(dir)
+
+	config := filepath.Join(dir, "config.yaml")
+	bio := filepath.Join(dir, "bio.yaml")
+
+	problems, err := ValidateFiles([]string{config}, func(string) bool { return true })
+	if err != nil {
+		t.Fatalf("Error validating config: %s", err)
+	}
+
+	expected := []Problem{
+		{File: bio, Line: 2, Message: `program "bio" is defined more than once`},
+		{File: bio, Line: 3, Message: `error reading code of program "bio": open ` + filepath.Join(dir, "missing.c") + `: no such file or directory`},
+		{File: config, Line: 3, Message: `included file "` + filepath.Join(dir, "missing.yaml") + `" is not found`},
+	}
+
+	if !reflect.DeepEqual(problems, expected) {
+		t.Errorf("Expected problems %v, got %v", expected, problems)
+	}
+}
@@ -0,0 +1,60 @@
+package config
+
+import "fmt"
+
+// expandLabelSets puts labels of label sets in front of labels of
+// counters, histograms and exemplars that refer to them with labels_from
+func expandLabelSets(config *Config) error {
+	for i := range config.Programs {
+		program := &config.Programs[i]
+
+		for j := range program.Metrics.Counters {
+			counter := &program.Metrics.Counters[j]
+
+			labels, err := labelsFrom(config.LabelSets, counter.LabelsFrom, counter.Labels)
+			if err != nil {
+				return fmt.Errorf("program %q: counter %q: %s", program.Name, counter.Name, err)
+			}
+
+			counter.Labels = labels
+		}
+
+		for j := range program.Metrics.Histograms {
+			histogram := &program.Metrics.Histograms[j]
+
+			labels, err := labelsFrom(config.LabelSets, histogram.LabelsFrom, histogram.Labels)
+			if err != nil {
+				return fmt.Errorf("program %q: histogram %q: %s", program.Name, histogram.Name, err)
+			}
+
+			histogram.Labels = labels
+
+			labels, err = labelsFrom(config.LabelSets, histogram.Exemplars.LabelsFrom, histogram.Exemplars.Labels)
+			if err != nil {
+				return fmt.Errorf("program %q: exemplars of histogram %q: %s", program.Name, histogram.Name, err)
+			}
+
+			histogram.Exemplars.Labels = labels
+		}
+	}
+
+	return nil
+}
+
+// labelsFrom returns labels of the label set followed by own labels
+func labelsFrom(sets map[string][]Label, name string, labels []Label) ([]Label, error) {
+	if name == "" {
+		return labels, nil
+	}
+
+	set, ok := sets[name]
+	if !ok {
+		return nil, fmt.Errorf("label set %q is not defined", name)
+	}
+
+	expanded := make([]Label, 0, len(set)+len(labels))
+	expanded = append(expanded, set...)
+	expanded = append(expanded, labels...)
+
+	return expanded, nil
+}
@@ -0,0 +1,135 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadLabelSets(t *testing.T) {
+	config, err := Load(strings.NewReader(`label_sets:
+  kube_pid:
+    - name: app_namespace
+      size: 8
+      reuse: true
+      decoders:
+        - name: kube_podnamespace
+    - name: app_pid
+      size: 8
+      decoders:
+        - name: uint
+programs:
+  - name: bio
+    metrics:
+      counters:
+        - name: bio_total
+          table: counts
+          labels_from: kube_pid
+          labels:
+            - name: device
+              size: 32
+              decoders:
+                - name: string
+      histograms:
+        - name: bio_latency_seconds
+          table: latency
+          bucket_type: exp2
+          labels_from: kube_pid
+`))
+	if err != nil {
+		t.Fatalf("Error loading config: %s", err)
+	}
+
+	names := func(labels []Label) string {
+		names := []string{}
+		for _, label := range labels {
+			names = append(names, label.Name)
+		}
+		return strings.Join(names, ",")
+	}
+
+	if labels := names(config.Programs[0].Metrics.Counters[0].Labels); labels != "app_namespace,app_pid,device" {
+		t.Errorf("Expected counter labels app_namespace,app_pid,device, got %s", labels)
+	}
+
+	if labels := names(config.Programs[0].Metrics.Histograms[0].Labels); labels != "app_namespace,app_pid" {
+		t.Errorf("Expected histogram labels app_namespace,app_pid, got %s", labels)
+	}
+
+	if labels := names(config.LabelSets["kube_pid"]); labels != "app_namespace,app_pid" {
+		t.Errorf("Expected label set to be unchanged, got %s", labels)
+	}
+
+	if _, err := Load(strings.NewReader("programs:\n  - name: bio\n    metrics:\n      counters:\n        - name: bio_total\n          labels_from: missing\n")); err == nil {
+		t.Errorf("Expected error loading config with unknown label set")
+	}
+}
+
+func TestLoadFileLabelSets(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"config.yaml": "include: [labels.yaml]\nprograms:\n  - name: bio\n    metrics:\n      counters:\n        - name: bio_total\n          labels_from: kube_pid\n",
+		"labels.yaml": "label_sets:\n  kube_pid:\n    - name: app_pid\n      size: 8\n",
+		"other.yaml":  "include: [labels.yaml]\nlabel_sets:\n  kube_pid: []\n",
+	})
+	defer os.RemoveAll(dir)
+
+	config, err := LoadFile(filepath.Join(dir, "config.yaml"))
+	if err != nil {
+		t.Fatalf("Error loading config: %s", err)
+	}
+
+	if labels := config.Programs[0].Metrics.Counters[0].Labels; len(labels) != 1 || labels[0].Name != "app_pid" {
+		t.Errorf("Expected labels from included label set, got %#v", labels)
+	}
+
+	if _, err := LoadFile(filepath.Join(dir, "other.yaml")); err == nil {
+		t.Errorf("Expected error loading label set defined in two files")
+	}
+}
+
+func TestValidateLabelSets(t *testing.T) {
+	data := []byte(`programs:
+  - name: bio
+    metrics:
+      counters:
+        - name: bio_total
+          table: counts
+          labels_from: kube_pid
+          labels:
+            - name: device
+              size: 4
+              decoders:
+                - name: uint
+        - name: bio_errors_total
+          table: counts
+          labels_from: missing
+label_sets:
+  kube_pid:
+    - name: app_pid
+      size: 3
+      decoders:
+        - name: uint
+`)
+
+	problems, err := Validate(data, func(string) bool { return true })
+	if err != nil {
+		t.Fatalf("Error validating config: %s", err)
+	}
+
+	expected := []string{
+		`line 19: label set "kube_pid": label "app_pid" of "kube_pid" has size 3, but uint decoder needs 1, 2, 4 or 8 bytes`,
+		`line 15: program "bio": metric "bio_errors_total": label set "missing" is not defined`,
+		`line 13: program "bio": labels of table "counts" add up to 0 bytes, but other metrics of the table have 7 bytes`,
+	}
+
+	if len(problems) != len(expected) {
+		t.Fatalf("Expected problems %v, got %v", expected, problems)
+	}
+
+	for i, problem := range problems {
+		if problem.String() != expected[i] {
+			t.Errorf("Expected problem %q, got %q", expected[i], problem)
+		}
+	}
+}
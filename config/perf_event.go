@@ -0,0 +1,167 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PerfEventType is a type of perf events, either a name like hardware
+// or a number of PERF_TYPE_* constant
+type PerfEventType string
+
+// PerfEventName is an event within its type, either a name like cpu-cycles
+// or a number that perf_event_open(2) expects as config
+type PerfEventName string
+
+// perfEventTypes maps type names to PERF_TYPE_* constants
+var perfEventTypes = map[string]int{
+	"hardware":   0,
+	"software":   1,
+	"tracepoint": 2,
+	"hw_cache":   3,
+	"raw":        4,
+	"breakpoint": 5,
+}
+
+// perfEventNames maps event names of types to PERF_COUNT_* constants
+var perfEventNames = map[int]map[string]int{
+	// PERF_TYPE_HARDWARE
+	0: {
+		"cpu-cycles":              0,
+		"cycles":                  0,
+		"instructions":            1,
+		"cache-references":        2,
+		"cache-misses":            3,
+		"branch-instructions":     4,
+		"branches":                4,
+		"branch-misses":           5,
+		"bus-cycles":              6,
+		"stalled-cycles-frontend": 7,
+		"stalled-cycles-backend":  8,
+		"ref-cycles":              9,
+	},
+	// PERF_TYPE_SOFTWARE
+	1: {
+		"cpu-clock":        0,
+		"task-clock":       1,
+		"page-faults":      2,
+		"faults":           2,
+		"context-switches": 3,
+		"cs":               3,
+		"cpu-migrations":   4,
+		"migrations":       4,
+		"minor-faults":     5,
+		"major-faults":     6,
+		"alignment-faults": 7,
+		"emulation-faults": 8,
+		"dummy":            9,
+		"bpf-output":       10,
+	},
+}
+
+// perfEventCaches maps cache names to PERF_COUNT_HW_CACHE_* constants
+var perfEventCaches = map[string]int{
+	"l1-dcache": 0,
+	"l1-icache": 1,
+	"llc":       2,
+	"dtlb":      3,
+	"itlb":      4,
+	"branch":    5,
+	"node":      6,
+}
+
+// perfEventCacheOps maps cache operations to PERF_COUNT_HW_CACHE_OP_* constants
+var perfEventCacheOps = map[string]int{
+	"load":     0,
+	"store":    1,
+	"prefetch": 2,
+}
+
+// perfEventCacheAccesses maps plural cache operations, which count accesses
+var perfEventCacheAccesses = map[string]int{
+	"loads":      0,
+	"stores":     1,
+	"prefetches": 2,
+}
+
+// Resolve returns type and config numbers of the perf event
+func (p PerfEvent) Resolve() (int, int, error) {
+	eventType, err := p.Type.resolve()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	eventConfig, err := p.Name.resolve(eventType)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return eventType, eventConfig, nil
+}
+
+func (t PerfEventType) resolve() (int, error) {
+	if number, err := strconv.Atoi(string(t)); err == nil {
+		return number, nil
+	}
+
+	number, ok := perfEventTypes[strings.ToLower(string(t))]
+	if !ok {
+		return 0, fmt.Errorf("unknown perf event type %q", t)
+	}
+
+	return number, nil
+}
+
+func (n PerfEventName) resolve(eventType int) (int, error) {
+	if number, err := strconv.ParseUint(string(n), 0, 32); err == nil {
+		return int(number), nil
+	}
+
+	name := strings.ToLower(string(n))
+
+	// Cache events are named like perf does it, for example llc-load-misses
+	if eventType == perfEventTypes["hw_cache"] {
+		return resolveCacheEvent(name)
+	}
+
+	if number, ok := perfEventNames[eventType][name]; ok {
+		return number, nil
+	}
+
+	return 0, fmt.Errorf("unknown perf event name %q for type %d", n, eventType)
+}
+
+// resolveCacheEvent turns <cache>-<op>s and <cache>-<op>-misses names into
+// cache | (op << 8) | (result << 16) config of PERF_TYPE_HW_CACHE
+func resolveCacheEvent(name string) (int, error) {
+	result := 0
+	event := name
+
+	if strings.HasSuffix(event, "-misses") {
+		result = 1
+		event = strings.TrimSuffix(event, "-misses")
+	}
+
+	separator := strings.LastIndex(event, "-")
+	if separator < 0 {
+		return 0, fmt.Errorf("unknown cache perf event name %q, expected <cache>-<op>s or <cache>-<op>-misses", name)
+	}
+
+	cache, ok := perfEventCaches[event[:separator]]
+	if !ok {
+		return 0, fmt.Errorf("unknown cache %q in perf event name %q", event[:separator], name)
+	}
+
+	ops := perfEventCacheOps
+	if result == 0 {
+		ops = perfEventCacheAccesses
+	}
+
+	op, ok := ops[event[separator+1:]]
+	if !ok {
+		return 0, fmt.Errorf("unknown cache operation %q in perf event name %q", event[separator+1:], name)
+	}
+
+	return cache | op<<8 | result<<16, nil
+}
@@ -0,0 +1,48 @@
+package config
+
+import "testing"
+
+func TestPerfEventResolve(t *testing.T) {
+	cases := []struct {
+		eventType PerfEventType
+		name      PerfEventName
+		typeID    int
+		config    int
+		err       bool
+	}{
+		{eventType: "hardware", name: "cpu-cycles", typeID: 0, config: 0},
+		{eventType: "hardware", name: "instructions", typeID: 0, config: 1},
+		{eventType: "software", name: "cpu-clock", typeID: 1, config: 0},
+		{eventType: "Software", name: "Context-Switches", typeID: 1, config: 3},
+		{eventType: "hw_cache", name: "llc-loads", typeID: 3, config: 0x2},
+		{eventType: "hw_cache", name: "LLC-load-misses", typeID: 3, config: 0x10002},
+		{eventType: "hw_cache", name: "l1-dcache-store-misses", typeID: 3, config: 0x10100},
+		{eventType: "hw_cache", name: "dtlb-prefetches", typeID: 3, config: 0x203},
+		{eventType: "3", name: "0x10002", typeID: 3, config: 0x10002},
+		{eventType: "1", name: "2", typeID: 1, config: 2},
+		{eventType: "raw", name: "0x1a3", typeID: 4, config: 0x1a3},
+		{eventType: "hardware", name: "bananas", err: true},
+		{eventType: "bananas", name: "0", err: true},
+		{eventType: "hw_cache", name: "llc-loadz", err: true},
+		{eventType: "hw_cache", name: "l3-load-misses", err: true},
+	}
+
+	for _, c := range cases {
+		typeID, config, err := PerfEvent{Type: c.eventType, Name: c.name}.Resolve()
+		if c.err {
+			if err == nil {
+				t.Errorf("Expected error resolving %s:%s, got %d:%d", c.eventType, c.name, typeID, config)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Error resolving %s:%s: %s", c.eventType, c.name, err)
+			continue
+		}
+
+		if typeID != c.typeID || config != c.config {
+			t.Errorf("Expected %s:%s to be %d:%#x, got %d:%#x", c.eventType, c.name, c.typeID, c.config, typeID, config)
+		}
+	}
+}
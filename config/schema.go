@@ -0,0 +1,137 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+//go:generate go run schema_gen.go
+
+// schemaEnums lists allowed values of enum types
+var schemaEnums = map[reflect.Type][]string{
+	reflect.TypeOf(HistogramBucketType("")): {HistogramBucketExp2, HistogramBucketLinear, HistogramBucketLogLinear, HistogramBucketCustom},
+	reflect.TypeOf(SinkValueMode("")):       {SinkValueCumulative, SinkValueDelta},
+	reflect.TypeOf(SinkOverflow("")):        {SinkOverflowDropOldest, SinkOverflowDropNewest, SinkOverflowBlock},
+}
+
+// schemaRequired lists fields that must be set in structs
+var schemaRequired = map[reflect.Type][]string{
+	reflect.TypeOf(Program{}):   {"name"},
+	reflect.TypeOf(Event{}):     {"name", "table"},
+	reflect.TypeOf(Counter{}):   {"name"},
+	reflect.TypeOf(Histogram{}): {"name", "bucket_type"},
+	reflect.TypeOf(Label{}):     {"name"},
+	reflect.TypeOf(Decoder{}):   {"name"},
+}
+
+// durationPattern matches values accepted by time.ParseDuration
+const durationPattern = `^-?([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$`
+
+// JSONSchema returns JSON Schema of the config generated from config structs
+func JSONSchema() ([]byte, error) {
+	definitions := map[string]interface{}{}
+
+	root := schemaType(reflect.TypeOf(Config{}), definitions)
+
+	schema := map[string]interface{}{
+		"$schema":     "http://json-schema.org/draft-07/schema#",
+		"title":       "kube-ebpf-exporter config",
+		"definitions": definitions,
+		"$ref":        root["$ref"],
+	}
+
+	data, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append(data, '\n'), nil
+}
+
+// schemaType describes the type, structs are added to definitions
+// and referenced by their name
+func schemaType(t reflect.Type, definitions map[string]interface{}) map[string]interface{} {
+	if values, ok := schemaEnums[t]; ok {
+		return map[string]interface{}{"type": "string", "enum": values}
+	}
+
+	// Perf events are either numbers or names
+	if t == reflect.TypeOf(PerfEventType("")) || t == reflect.TypeOf(PerfEventName("")) {
+		return map[string]interface{}{"type": []string{"string", "integer"}}
+	}
+
+	if t == reflect.TypeOf(time.Duration(0)) {
+		return map[string]interface{}{"type": "string", "pattern": durationPattern}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		// Pointers are for optional values that can be zero
+		return schemaType(t.Elem(), definitions)
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": schemaType(t.Elem(), definitions)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaType(t.Elem(), definitions)}
+	case reflect.Struct:
+		ref := map[string]interface{}{"$ref": "#/definitions/" + t.Name()}
+
+		if _, ok := definitions[t.Name()]; ok {
+			return ref
+		}
+
+		// Placeholder stops recursion for self referencing types
+		definitions[t.Name()] = nil
+
+		properties := map[string]interface{}{}
+		schemaProperties(t, properties, definitions)
+
+		definition := map[string]interface{}{
+			"type":                 "object",
+			"properties":           properties,
+			"additionalProperties": false,
+		}
+
+		if required, ok := schemaRequired[t]; ok {
+			definition["required"] = required
+		}
+
+		definitions[t.Name()] = definition
+
+		return ref
+	default:
+		panic(fmt.Sprintf("no schema for type %s", t))
+	}
+}
+
+// schemaProperties describes fields of the struct, fields of inlined
+// structs are described as fields of the struct itself
+func schemaProperties(t reflect.Type, properties map[string]interface{}, definitions map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		tag := strings.Split(field.Tag.Get("yaml"), ",")
+		if len(tag) > 1 && tag[1] == "inline" {
+			schemaProperties(field.Type, properties, definitions)
+			continue
+		}
+
+		if tag[0] == "" || tag[0] == "-" {
+			continue
+		}
+
+		properties[tag[0]] = schemaType(field.Type, definitions)
+	}
+}
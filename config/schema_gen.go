@@ -0,0 +1,22 @@
+// +build ignore
+
+// This program generates schema.json from config structs
+package main
+
+import (
+	"io/ioutil"
+	"log"
+
+	"github.com/ahas-sigs/kube-ebpf-exporter/config"
+)
+
+func main() {
+	schema, err := config.JSONSchema()
+	if err != nil {
+		log.Fatalf("Error generating schema: %s", err)
+	}
+
+	if err := ioutil.WriteFile("schema.json", schema, 0644); err != nil {
+		log.Fatalf("Error writing schema: %s", err)
+	}
+}
@@ -0,0 +1,23 @@
+package config
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestSchemaUpToDate(t *testing.T) {
+	expected, err := JSONSchema()
+	if err != nil {
+		t.Fatalf("Error generating schema: %s", err)
+	}
+
+	schema, err := ioutil.ReadFile("schema.json")
+	if err != nil {
+		t.Fatalf("Error reading schema: %s", err)
+	}
+
+	if !bytes.Equal(schema, expected) {
+		t.Errorf("Schema in schema.json is outdated, run go generate ./config")
+	}
+}
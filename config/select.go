@@ -0,0 +1,84 @@
+package config
+
+import "fmt"
+
+// Select keeps programs and probe sets whose conditions match the kernel,
+// probes of matching probe sets are merged into their programs. Reasons
+// for skipping programs and probe sets are returned to be logged.
+func Select(config Config, kernel Kernel) (Config, []string, error) {
+	selected := config
+	selected.Programs = []Program{}
+
+	skipped := []string{}
+	names := map[string]bool{}
+
+	for _, program := range config.Programs {
+		ok, reason, err := program.Condition.Match(kernel)
+		if err != nil {
+			return config, nil, fmt.Errorf("error checking conditions of program %q: %s", program.Name, err)
+		}
+
+		if !ok {
+			skipped = append(skipped, fmt.Sprintf("program %q is skipped: %s", program.Name, reason))
+			continue
+		}
+
+		if names[program.Name] {
+			return config, nil, fmt.Errorf("more than one variant of program %q matches kernel %s", program.Name, kernel.Release())
+		}
+
+		names[program.Name] = true
+
+		program.Kprobes = copyProbes(program.Kprobes)
+		program.Kretprobes = copyProbes(program.Kretprobes)
+		program.Tracepoints = copyProbes(program.Tracepoints)
+		program.RawTracepoints = copyProbes(program.RawTracepoints)
+
+		for i, probes := range program.Probes {
+			ok, reason, err := probes.Condition.Match(kernel)
+			if err != nil {
+				return config, nil, fmt.Errorf("error checking conditions of probe set #%d of program %q: %s", i, program.Name, err)
+			}
+
+			if !ok {
+				skipped = append(skipped, fmt.Sprintf("probe set #%d of program %q is skipped: %s", i, program.Name, reason))
+				continue
+			}
+
+			merges := []struct {
+				kind string
+				dst  map[string]string
+				src  map[string]string
+			}{
+				{"kprobe", program.Kprobes, probes.Kprobes},
+				{"kretprobe", program.Kretprobes, probes.Kretprobes},
+				{"tracepoint", program.Tracepoints, probes.Tracepoints},
+				{"raw tracepoint", program.RawTracepoints, probes.RawTracepoints},
+			}
+
+			for _, merge := range merges {
+				for target, function := range merge.src {
+					if _, ok := merge.dst[target]; ok {
+						return config, nil, fmt.Errorf("program %q: %s %q is attached by more than one matching probe set", program.Name, merge.kind, target)
+					}
+
+					merge.dst[target] = function
+				}
+			}
+		}
+
+		selected.Programs = append(selected.Programs, program)
+	}
+
+	return selected, skipped, nil
+}
+
+// copyProbes copies probes so that merging does not change the original
+func copyProbes(probes map[string]string) map[string]string {
+	copied := map[string]string{}
+	for target, function := range probes {
+		copied[target] = function
+	}
+
+	return copied
+}
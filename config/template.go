@@ -0,0 +1,114 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// TemplateData is available to templates in code and cflags of programs
+type TemplateData struct {
+	// Env holds environment variables, referencing unset ones is an error
+	Env map[string]string
+	// NodeID is the node id of the exporter
+	NodeID string
+	// Kernel is the release of the running kernel
+	Kernel string
+	// Program is the name of the program being rendered
+	Program string
+}
+
+// Environ returns environment variables of the process for templates
+func Environ() map[string]string {
+	env := map[string]string{}
+
+	for _, pair := range os.Environ() {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) == 2 {
+			env[parts[0]] = parts[1]
+		}
+	}
+
+	return env
+}
+
+// RenderTemplates executes code and cflags of programs that enable
+// templates as Go templates, code of other programs is left as is
+func RenderTemplates(config Config, data TemplateData) (Config, error) {
+	rendered := config
+	rendered.Programs = make([]Program, len(config.Programs))
+
+	for i, program := range config.Programs {
+		if !program.Templates {
+			rendered.Programs[i] = program
+			continue
+		}
+
+		data.Program = program.Name
+
+		code, err := renderTemplate(program.Name+" code", program.Code, data)
+		if err != nil {
+			return config, fmt.Errorf("error rendering code of program %q: %s", program.Name, err)
+		}
+
+		program.Code = code
+
+		if program.Cflags != nil {
+			cflags := make([]string, len(program.Cflags))
+
+			for j, cflag := range program.Cflags {
+				cflags[j], err = renderTemplate(program.Name+" cflags", cflag, data)
+				if err != nil {
+					return config, fmt.Errorf("error rendering cflags of program %q: %s", program.Name, err)
+				}
+			}
+
+			program.Cflags = cflags
+		}
+
+		rendered.Programs[i] = program
+	}
+
+	return rendered, nil
+}
+
+// parseTemplate parses the template, templates can use env function
+// to read environment variables with a default: {{env "MAX_DISKS" "16"}}
+func parseTemplate(name string, text string) (*template.Template, error) {
+	funcs := template.FuncMap{
+		"env": func(name string, defaults ...string) (string, error) {
+			if value, ok := os.LookupEnv(name); ok {
+				return value, nil
+			}
+
+			if len(defaults) > 0 {
+				return defaults[0], nil
+			}
+
+			return "", fmt.Errorf("environment variable %q is not set", name)
+		},
+	}
+
+	return template.New(name).Option("missingkey=error").Funcs(funcs).Parse(text)
+}
+
+// renderTemplate executes the template, text without actions is returned as is
+func renderTemplate(name string, text string, data TemplateData) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+
+	tmpl, err := parseTemplate(name, text)
+	if err != nil {
+		return "", err
+	}
+
+	buf := &bytes.Buffer{}
+	if err := tmpl.Execute(buf, data); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
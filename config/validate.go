@@ -0,0 +1,840 @@
+package config
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// labelName matches valid prometheus label names
+var labelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// metricNamespace matches valid prefixes of prometheus metric names
+var metricNamespace = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+// typeErrorLine extracts the line from yaml type errors
+var typeErrorLine = regexp.MustCompile(`^line (\d+): (.*)$`)
+
+// Problem is an issue found in the config by Validate
+type Problem struct {
+	// File is the config file, empty if the config is not read from a file
+	File string
+	// Line is the line in the config file, zero if it is unknown
+	Line    int
+	Message string
+}
+
+// String formats the problem with its file and line number
+func (p Problem) String() string {
+	switch {
+	case p.File != "" && p.Line != 0:
+		return fmt.Sprintf("%s:%d: %s", p.File, p.Line, p.Message)
+	case p.File != "":
+		return fmt.Sprintf("%s: %s", p.File, p.Message)
+	case p.Line != 0:
+		return fmt.Sprintf("line %d: %s", p.Line, p.Message)
+	default:
+		return p.Message
+	}
+}
+
+// validator collects problems and resolves their lines
+type validator struct {
+	file     string
+	root     *yaml.Node
+	known    func(string) bool
+	seen     map[string]bool
+	programs map[string]bool
+	metrics  map[string]metricOwner
+	variants int
+	// labelSets are label sets of all config files for labels_from
+	labelSets map[string][]Label
+	// global is the global section of all config files
+	global   Global
+	sets     map[string]bool
+	problems []Problem
+}
+
+// metricOwner is the program variant that defines a metric
+type metricOwner struct {
+	program string
+	variant int
+}
+
+// newValidator creates a validator, known reports whether
+// a decoder with the provided name exists
+func newValidator(known func(string) bool) *validator {
+	return &validator{
+		known:    known,
+		seen:     map[string]bool{},
+		programs: map[string]bool{},
+		metrics:  map[string]metricOwner{},
+		sets:     map[string]bool{},
+	}
+}
+
+// Validate parses the config and checks it without kernel access,
+// known reports whether a decoder with the provided name exists
+func Validate(data []byte, known func(string) bool) ([]Problem, error) {
+	v := newValidator(known)
+
+	// Label sets and external labels can be used before they are defined
+	sets := struct {
+		Global    Global             `yaml:"global"`
+		LabelSets map[string][]Label `yaml:"label_sets"`
+	}{}
+
+	yaml.Unmarshal(data, &sets)
+
+	v.labelSets = sets.LabelSets
+	v.global = sets.Global
+
+	if _, err := v.document(data); err != nil {
+		return nil, err
+	}
+
+	return v.problems, nil
+}
+
+// ValidateFiles checks config files along with files they include
+// as one config, programs and metrics are checked across all files
+func ValidateFiles(paths []string, known func(string) bool) ([]Problem, error) {
+	v := newValidator(known)
+
+	// Label sets can be defined in any of the files, loading errors
+	// are found again by validation and reported as problems
+	all := Config{}
+	l := &loader{seen: map[string]bool{}}
+	for _, path := range paths {
+		if err := l.load(&all, path); err != nil {
+			break
+		}
+	}
+
+	v.labelSets = all.LabelSets
+	v.global = all.Global
+
+	for _, path := range paths {
+		if err := v.validateFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	return v.problems, nil
+}
+
+// validateFile checks the config file and files it includes
+func (v *validator) validateFile(path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	if v.seen[abs] {
+		v.problems = append(v.problems, Problem{File: path, Message: "config file is loaded more than once"})
+		return nil
+	}
+
+	v.seen[abs] = true
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	v.file = path
+
+	config, err := v.document(data)
+	if err != nil {
+		return fmt.Errorf("error parsing config file %q: %s", path, err)
+	}
+
+	dir := filepath.Dir(path)
+
+	for i, program := range config.Programs {
+		if program.CodeFile == "" {
+			continue
+		}
+
+		if err := loadCode(&program, dir); err != nil {
+			v.add([]interface{}{"programs", i, "code_file"}, "%s", err)
+		}
+	}
+
+	root := v.root
+
+	for i, pattern := range config.Include {
+		// Included files replace the current file and its nodes
+		v.file, v.root = path, root
+
+		paths, err := includeFiles(dir, pattern)
+		if err != nil {
+			v.add([]interface{}{"include", i}, "%s", err)
+			continue
+		}
+
+		for _, included := range paths {
+			if err := v.validateFile(included); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// document checks a single config document and returns the decoded config
+func (v *validator) document(data []byte) (Config, error) {
+	config := Config{}
+
+	root, missing, err := decodeExpanded(data, &config)
+	for _, env := range missing {
+		v.problems = append(v.problems, Problem{File: v.file, Line: env.line, Message: fmt.Sprintf("environment variable %q is not set and has no default", env.name)})
+	}
+
+	v.root = root
+
+	// Decoding goes on after type errors and unknown fields,
+	// so that the rest of the config can still be checked
+	if err != nil {
+		typeErr, ok := err.(*yaml.TypeError)
+		if !ok {
+			return config, err
+		}
+
+		for _, message := range typeErr.Errors {
+			problem := Problem{File: v.file, Message: message}
+
+			if match := typeErrorLine.FindStringSubmatch(message); match != nil {
+				problem.Line, _ = strconv.Atoi(match[1])
+				problem.Message = match[2]
+			}
+
+			v.problems = append(v.problems, problem)
+		}
+	}
+
+	v.config(config)
+
+	return config, nil
+}
+
+// add records a problem for the provided path of keys and indexes
+func (v *validator) add(path []interface{}, format string, args ...interface{}) {
+	v.problems = append(v.problems, Problem{
+		File:    v.file,
+		Line:    v.line(path),
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// line returns the line of the deepest node found by the path
+func (v *validator) line(path []interface{}) int {
+	node := v.root
+	if node.Kind == yaml.DocumentNode {
+		if len(node.Content) == 0 {
+			return 0
+		}
+		node = node.Content[0]
+	}
+
+	for _, elem := range path {
+		var next *yaml.Node
+
+		switch key := elem.(type) {
+		case string:
+			if node.Kind != yaml.MappingNode {
+				break
+			}
+
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				if node.Content[i].Value == key {
+					next = node.Content[i+1]
+					break
+				}
+			}
+		case int:
+			if node.Kind == yaml.SequenceNode && key < len(node.Content) {
+				next = node.Content[key]
+			}
+		}
+
+		if next == nil {
+			break
+		}
+
+		node = next
+	}
+
+	return node.Line
+}
+
+// extend returns a copy of the path with more elements
+func extend(path []interface{}, elems ...interface{}) []interface{} {
+	return append(append([]interface{}{}, path...), elems...)
+}
+
+func (v *validator) config(config Config) {
+	if err := checkVersion(config.Version); err != nil {
+		v.add([]interface{}{"version"}, "%s", err)
+	}
+
+	v.sink(config.Sink)
+
+	if config.Global.Namespace != "" && !metricNamespace.MatchString(config.Global.Namespace) {
+		v.add([]interface{}{"global", "namespace"}, "namespace %q is not a valid metric name prefix", config.Global.Namespace)
+	}
+
+	owners := extensionOwners{}
+
+	for _, section := range []struct {
+		key    string
+		labels map[string]string
+	}{{"external_labels", config.Global.ExternalLabels}, {"node_labels", config.Global.NodeLabels}} {
+		for _, name := range sortedKeys(section.labels) {
+			if err := checkExternalLabel(name); err != nil {
+				v.add([]interface{}{"global", section.key, name}, "%s", err)
+				continue
+			}
+
+			if err := owners.check(name); err != nil {
+				v.add([]interface{}{"global", section.key, name}, "%s", err)
+			}
+		}
+	}
+
+	names := []string{}
+	for name := range config.LabelSets {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		path := []interface{}{"label_sets", name}
+
+		if v.sets[name] {
+			v.add(path, "label set %q is defined more than once", name)
+		}
+
+		v.sets[name] = true
+
+		v.labels(path, fmt.Sprintf("label set %q", name), name, config.LabelSets[name])
+	}
+
+	for i, program := range config.Programs {
+		path := []interface{}{"programs", i}
+
+		conditional, defined := v.programs[program.Name]
+
+		// Variants of a program for different kernels share the name
+		if program.Name == "" {
+			v.add(path, "program #%d has no name", i)
+		} else if defined && (!conditional || program.Condition.Empty()) {
+			v.add(extend(path, "name"), "program %q is defined more than once", program.Name)
+		}
+
+		v.programs[program.Name] = (conditional || !defined) && !program.Condition.Empty()
+
+		v.program(path, program)
+	}
+}
+
+func (v *validator) program(path []interface{}, program Program) {
+	events := map[string]Event{}
+	keySizes := map[string]uint{}
+
+	v.variants++
+	variant := v.variants
+
+	checkName := func(path []interface{}, name string) {
+		if name == "" {
+			v.add(path, "program %q has a metric without name", program.Name)
+			return
+		}
+
+		// Programs in different namespaces can have metrics with the same name
+		fqName := MetricNamespace(v.global, program) + "_" + name
+
+		if owner, ok := v.metrics[fqName]; ok && (owner.program != program.Name || owner.variant == variant) {
+			v.add(extend(path, "name"), "program %q: metric %q is already defined in program %q", program.Name, name, owner.program)
+			return
+		}
+
+		v.metrics[fqName] = metricOwner{program: program.Name, variant: variant}
+	}
+
+	// Metrics reading the same table must decode keys of the same size
+	checkKeySize := func(path []interface{}, table string, labels []Label) {
+		size := keySize(labels)
+
+		if previous, ok := keySizes[table]; ok && previous != size {
+			v.add(extend(path, "labels"), "program %q: labels of table %q add up to %d bytes, but other metrics of the table have %d bytes", program.Name, table, size, previous)
+			return
+		}
+
+		keySizes[table] = size
+	}
+
+	v.condition(path, program.Name, program.Condition)
+
+	if program.Namespace != "" && !metricNamespace.MatchString(program.Namespace) {
+		v.add(extend(path, "namespace"), "program %q: namespace %q is not a valid metric name prefix", program.Name, program.Namespace)
+	}
+
+	if program.Templates {
+		if _, err := parseTemplate(program.Name+" code", program.Code); err != nil {
+			v.add(extend(path, "code"), "program %q: error parsing code template: %s", program.Name, err)
+		}
+
+		for i, cflag := range program.Cflags {
+			if _, err := parseTemplate(program.Name+" cflags", cflag); err != nil {
+				v.add(extend(path, "cflags", i), "program %q: error parsing cflags template: %s", program.Name, err)
+			}
+		}
+	}
+
+	for i, probes := range program.Probes {
+		v.condition(extend(path, "probes", i), program.Name, probes.Condition)
+	}
+
+	for i, perfEvent := range program.PerfEvents {
+		perfEventPath := extend(path, "perf_events", i)
+
+		if _, _, err := perfEvent.Resolve(); err != nil {
+			v.add(perfEventPath, "program %q: %s", program.Name, err)
+		}
+
+		if perfEvent.Target == "" {
+			v.add(perfEventPath, "program %q: perf event %s:%s has no target", program.Name, perfEvent.Type, perfEvent.Name)
+		}
+
+		// Without either of them the event only counts and never calls the target
+		if perfEvent.SamplePeriod == 0 && perfEvent.SampleFrequency == 0 {
+			v.add(perfEventPath, "program %q: perf event %s:%s needs either sample_period or sample_frequency", program.Name, perfEvent.Type, perfEvent.Name)
+		}
+	}
+
+	for i, event := range program.Events {
+		eventPath := extend(path, "events", i)
+
+		if event.Name == "" {
+			v.add(eventPath, "program %q has an event without name", program.Name)
+		} else if _, ok := events[event.Name]; ok {
+			v.add(extend(eventPath, "name"), "program %q: event %q is defined more than once", program.Name, event.Name)
+		}
+
+		events[event.Name] = event
+
+		if event.Table == "" {
+			v.add(eventPath, "program %q: event %q has no table", program.Name, event.Name)
+		}
+
+		v.labels(extend(eventPath, "labels"), fmt.Sprintf("program %q", program.Name), event.Name, event.Labels)
+	}
+
+	for i, counter := range program.Metrics.Counters {
+		counterPath := extend(path, "metrics", "counters", i)
+
+		checkName(counterPath, counter.Name)
+
+		labels := v.labelsFrom(counterPath, program.Name, counter.Name, counter.LabelsFrom, counter.Labels)
+
+		if !v.source(counterPath, program.Name, counter.Name, counter.Table, counter.Event, labels, len(labels)-len(counter.Labels), events) {
+			continue
+		}
+
+		v.external(counterPath, program.Name, counter.Name, labels)
+
+		if counter.Table != "" {
+			v.labels(extend(counterPath, "labels"), fmt.Sprintf("program %q", program.Name), counter.Name, counter.Labels)
+			checkKeySize(counterPath, counter.Table, labels)
+			v.maxSeries(counterPath, program.Name, counter.Name, counter.MaxSeries)
+		}
+	}
+
+	for i, histogram := range program.Metrics.Histograms {
+		histogramPath := extend(path, "metrics", "histograms", i)
+
+		checkName(histogramPath, histogram.Name)
+
+		if len(histogram.Quantiles) > 0 {
+			checkName(histogramPath, histogram.QuantileName())
+		}
+
+		labels := v.labelsFrom(histogramPath, program.Name, histogram.Name, histogram.LabelsFrom, histogram.Labels)
+
+		v.quantiles(histogramPath, program.Name, histogram, labels, len(labels)-len(histogram.Labels))
+
+		if len(labels) < 1 {
+			v.add(histogramPath, "program %q: histogram %q needs at least one label for buckets", program.Name, histogram.Name)
+		}
+
+		switch histogram.BucketType {
+		case HistogramBucketExp2, HistogramBucketLinear:
+		case HistogramBucketLogLinear:
+			if n := histogram.BucketSubBuckets; n < 1 || n&(n-1) != 0 {
+				v.add(extend(histogramPath, "bucket_sub_buckets"), "program %q: histogram %q needs bucket_sub_buckets to be a power of two, got %d", program.Name, histogram.Name, n)
+			}
+		case HistogramBucketCustom:
+			v.boundaries(histogramPath, program.Name, histogram)
+		default:
+			v.add(extend(histogramPath, "bucket_type"), "program %q: histogram %q has unknown bucket_type %q", program.Name, histogram.Name, histogram.BucketType)
+		}
+
+		if histogram.Native && histogram.BucketType != HistogramBucketExp2 {
+			v.add(extend(histogramPath, "native"), "program %q: histogram %q can only be native with exp2 buckets, not %q", program.Name, histogram.Name, histogram.BucketType)
+		}
+
+		if histogram.Native && histogram.BucketMultiplier < 0 {
+			v.add(extend(histogramPath, "bucket_multiplier"), "program %q: native histogram %q needs positive bucket_multiplier", program.Name, histogram.Name)
+		}
+
+		v.slots(histogramPath, program.Name, histogram)
+
+		if histogram.BucketMax == histogram.BucketMin {
+			v.add(extend(histogramPath, "bucket_max"), "program %q: histogram %q has zero size buckets: bucket_min and bucket_max are both %d", program.Name, histogram.Name, histogram.BucketMin)
+		} else if histogram.BucketMax < histogram.BucketMin {
+			v.add(extend(histogramPath, "bucket_max"), "program %q: histogram %q has bucket_max %d below bucket_min %d", program.Name, histogram.Name, histogram.BucketMax, histogram.BucketMin)
+		}
+
+		if !v.source(histogramPath, program.Name, histogram.Name, histogram.Table, histogram.Event, labels, len(labels)-len(histogram.Labels), events) {
+			continue
+		}
+
+		// The last label of histograms is the bucket
+		if len(labels) > 0 {
+			v.external(histogramPath, program.Name, histogram.Name, labels[:len(labels)-1])
+		}
+
+		if histogram.Table != "" {
+			v.labels(extend(histogramPath, "labels"), fmt.Sprintf("program %q", program.Name), histogram.Name, histogram.Labels)
+			checkKeySize(histogramPath, histogram.Table, labels)
+			v.maxSeries(histogramPath, program.Name, histogram.Name, histogram.MaxSeries)
+		}
+
+		v.exemplars(histogramPath, program.Name, histogram)
+	}
+}
+
+// sink checks settings of the sink that are only used when files are written
+func (v *validator) sink(sink Sink) {
+	if level := sink.CompressionLevel; level != nil && (*level < gzip.HuffmanOnly || *level > gzip.BestCompression) {
+		v.add([]interface{}{"sink", "compression_level"}, "sink compression_level %d is outside of [%d .. %d]", *level, gzip.HuffmanOnly, gzip.BestCompression)
+	}
+
+	switch sink.ValueMode {
+	case "", SinkValueCumulative, SinkValueDelta:
+	default:
+		v.add([]interface{}{"sink", "value_mode"}, "sink has unknown value_mode %q", sink.ValueMode)
+	}
+
+	switch sink.OverflowPolicy {
+	case "", SinkOverflowDropOldest, SinkOverflowDropNewest, SinkOverflowBlock:
+	default:
+		v.add([]interface{}{"sink", "overflow_policy"}, "sink has unknown overflow_policy %q", sink.OverflowPolicy)
+	}
+}
+
+// exemplars checks that exemplars come from a table with the same keys
+// as the histogram table and that their labels can be decoded
+func (v *validator) exemplars(path []interface{}, program string, histogram Histogram) {
+	exemplars := histogram.Exemplars
+	if exemplars.Table == "" && exemplars.LabelsFrom == "" && len(exemplars.Labels) == 0 {
+		return
+	}
+
+	path = extend(path, "exemplars")
+
+	if exemplars.Table == "" {
+		v.add(path, "program %q: exemplars of histogram %q have no table", program, histogram.Name)
+	}
+
+	if histogram.Table == "" {
+		v.add(path, "program %q: exemplars of histogram %q need the histogram to read a table", program, histogram.Name)
+	}
+
+	if histogram.Native {
+		v.add(path, "program %q: native histogram %q cannot have exemplars", program, histogram.Name)
+	}
+
+	labels := v.labelsFrom(path, program, histogram.Name, exemplars.LabelsFrom, exemplars.Labels)
+	if len(labels) == 0 {
+		v.add(path, "program %q: exemplars of histogram %q need labels to decode values of table %q", program, histogram.Name, exemplars.Table)
+	}
+
+	for i, label := range exemplars.Labels {
+		if label.Name != "" && !labelName.MatchString(label.Name) {
+			v.add(extend(path, "labels", i, "name"), "program %q: exemplar label %q of histogram %q is not a valid label name", program, label.Name, histogram.Name)
+		}
+	}
+
+	v.labels(extend(path, "labels"), fmt.Sprintf("program %q", program), histogram.Name, exemplars.Labels)
+}
+
+// maxSeries checks that table-backed metrics do not set max_series,
+// their series are limited by the size of the table instead
+func (v *validator) maxSeries(path []interface{}, program string, metric string, maxSeries int) {
+	if maxSeries != 0 {
+		v.add(extend(path, "max_series"), "program %q: metric %q reads a table, max_series only limits metrics counted from events", program, metric)
+	}
+}
+
+// quantiles checks that quantiles are between 0 and 1 and that
+// the quantile label does not clash with labels of the histogram,
+// the first inherited labels come from the label set of the histogram
+func (v *validator) quantiles(path []interface{}, program string, histogram Histogram, labels []Label, inherited int) {
+	if histogram.NoBuckets && len(histogram.Quantiles) == 0 {
+		v.add(extend(path, "no_buckets"), "program %q: histogram %q has no_buckets, but no quantiles to export instead", program, histogram.Name)
+	}
+
+	for i, quantile := range histogram.Quantiles {
+		if quantile < 0 || quantile > 1 {
+			v.add(extend(path, "quantiles", i), "program %q: histogram %q has quantile %g outside of [0 .. 1]", program, histogram.Name, quantile)
+		}
+	}
+
+	if len(histogram.Quantiles) == 0 {
+		return
+	}
+
+	// The last label of histograms is the bucket
+	for i, label := range labels {
+		if label.Name != "quantile" || i == len(labels)-1 {
+			continue
+		}
+
+		labelPath := extend(path, "labels_from")
+		if i >= inherited {
+			labelPath = extend(path, "labels", i-inherited, "name")
+		}
+
+		v.add(labelPath, "program %q: label \"quantile\" of histogram %q clashes with the label of quantiles", program, histogram.Name)
+	}
+}
+
+// slots checks that underflow, overflow and sum slots are outside
+// of buckets and do not share slots with each other
+func (v *validator) slots(path []interface{}, program string, histogram Histogram) {
+	slots := []struct {
+		name string
+		slot *int
+	}{
+		{"underflow_slot", histogram.UnderflowSlot},
+		{"overflow_slot", histogram.OverflowSlot},
+		{"sum_slot", histogram.SumSlot},
+	}
+
+	used := map[int]string{}
+
+	for _, s := range slots {
+		if s.slot == nil {
+			continue
+		}
+
+		slot := *s.slot
+
+		if histogram.Native && s.name != "sum_slot" {
+			v.add(extend(path, s.name), "program %q: native histogram %q cannot have %s", program, histogram.Name, s.name)
+		}
+
+		if slot >= histogram.BucketMin && slot <= histogram.BucketMax {
+			v.add(extend(path, s.name), "program %q: histogram %q has %s %d inside of buckets [%d .. %d]", program, histogram.Name, s.name, slot, histogram.BucketMin, histogram.BucketMax)
+		}
+
+		if other, ok := used[slot]; ok {
+			v.add(extend(path, s.name), "program %q: histogram %q has slot %d as both %s and %s", program, histogram.Name, slot, other, s.name)
+		}
+
+		used[slot] = s.name
+	}
+
+	// Default sum slot is only checked against other slots
+	if histogram.SumSlot == nil {
+		if other, ok := used[histogram.SumKey()]; ok {
+			v.add(extend(path, other), "program %q: histogram %q has slot %d as both %s and default sum slot, set sum_slot to another slot", program, histogram.Name, histogram.SumKey(), other)
+		}
+	}
+}
+
+// boundaries checks that boundaries of custom buckets are increasing
+// and that every bucket between bucket_min and bucket_max has one
+func (v *validator) boundaries(path []interface{}, program string, histogram Histogram) {
+	boundaries := histogram.BucketBoundaries
+	if len(boundaries) == 0 {
+		v.add(path, "program %q: histogram %q with custom buckets needs bucket_boundaries", program, histogram.Name)
+		return
+	}
+
+	for i := 1; i < len(boundaries); i++ {
+		if boundaries[i] <= boundaries[i-1] {
+			v.add(extend(path, "bucket_boundaries", i), "program %q: histogram %q has bucket boundary %g not above the previous one %g", program, histogram.Name, boundaries[i], boundaries[i-1])
+		}
+	}
+
+	if histogram.BucketMin < 0 {
+		v.add(extend(path, "bucket_min"), "program %q: histogram %q has negative bucket_min %d with custom buckets", program, histogram.Name, histogram.BucketMin)
+	}
+
+	if histogram.BucketMax >= len(boundaries) {
+		v.add(extend(path, "bucket_max"), "program %q: histogram %q has bucket_max %d, but only %d bucket_boundaries", program, histogram.Name, histogram.BucketMax, len(boundaries))
+	}
+}
+
+// condition checks that kernel version constraints can be parsed
+// and that required features are named
+func (v *validator) condition(path []interface{}, program string, condition Condition) {
+	if condition.KernelVersion != "" {
+		if _, err := ParseVersionConstraints(condition.KernelVersion); err != nil {
+			v.add(extend(path, "kernel_version"), "program %q: %s", program, err)
+		}
+	}
+
+	for i, symbol := range condition.Requires.Symbols {
+		if symbol == "" {
+			v.add(extend(path, "requires", "symbols", i), "program %q: required symbol has no name", program)
+		}
+	}
+
+	for i, tracepoint := range condition.Requires.Tracepoints {
+		if parts := strings.Split(tracepoint, ":"); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			v.add(extend(path, "requires", "tracepoints", i), "program %q: required tracepoint %q is not in category:name form", program, tracepoint)
+		}
+	}
+
+	for i, option := range condition.Requires.KernelConfig {
+		if option == "" {
+			v.add(extend(path, "requires", "kernel_config", i), "program %q: required kernel config option has no name", program)
+		}
+	}
+}
+
+// external checks that labels of the metric are not external labels
+func (v *validator) external(path []interface{}, program string, metric string, labels []Label) {
+	for _, label := range labels {
+		if isExternalLabel(v.global, label.Name) {
+			v.add(path, "program %q: label %q of metric %q is also an external label", program, label.Name, metric)
+		}
+	}
+}
+
+// labelsFrom returns labels of the metric with labels of its label set
+func (v *validator) labelsFrom(path []interface{}, program string, metric string, name string, labels []Label) []Label {
+	expanded, err := labelsFrom(v.labelSets, name, labels)
+	if err != nil {
+		v.add(extend(path, "labels_from"), "program %q: metric %q: %s", program, metric, err)
+		return labels
+	}
+
+	return expanded
+}
+
+// source checks that a metric reads either a table or an event,
+// labels of event-backed metrics must be decoded by the event,
+// the first inherited labels come from the label set of the metric
+func (v *validator) source(path []interface{}, program string, metric string, table string, event string, labels []Label, inherited int, events map[string]Event) bool {
+	if table == "" && event == "" {
+		v.add(path, "program %q: metric %q has neither table nor event", program, metric)
+		return false
+	}
+
+	if table != "" && event != "" {
+		v.add(extend(path, "event"), "program %q: metric %q has both table and event", program, metric)
+		return false
+	}
+
+	if event == "" {
+		return true
+	}
+
+	source, ok := events[event]
+	if !ok {
+		v.add(extend(path, "event"), "program %q: metric %q refers to unknown event %q", program, metric, event)
+		return false
+	}
+
+	decoded := map[string]bool{}
+	for _, label := range source.Labels {
+		decoded[label.Name] = true
+	}
+
+	for i, label := range labels {
+		labelPath := extend(path, "labels_from")
+		if i >= inherited {
+			labelPath = extend(path, "labels", i-inherited)
+		}
+
+		if !decoded[label.Name] {
+			v.add(labelPath, "program %q: label %q of metric %q is not decoded by event %q", program, label.Name, metric, event)
+		}
+	}
+
+	return true
+}
+
+// labels checks that labels at the path can be decoded from raw bytes,
+// problems start with the prefix naming the program or the label set
+func (v *validator) labels(path []interface{}, prefix string, owner string, labels []Label) {
+	for i, label := range labels {
+		labelPath := extend(path, i)
+
+		if label.Name == "" {
+			v.add(labelPath, "%s: label #%d of %q has no name", prefix, i, owner)
+		}
+
+		if label.Size == 0 {
+			v.add(labelPath, "%s: label %q of %q has zero size", prefix, label.Name, owner)
+		}
+
+		if len(label.Decoders) == 0 {
+			v.add(labelPath, "%s: label %q of %q has no decoders", prefix, label.Name, owner)
+		}
+
+		for j, decoder := range label.Decoders {
+			if !v.known(decoder.Name) {
+				v.add(extend(labelPath, "decoders", j), "%s: label %q of %q uses unknown decoder %q", prefix, label.Name, owner, decoder.Name)
+				continue
+			}
+
+			// Only the first decoder gets raw bytes
+			if j == 0 && decoder.Name == "uint" {
+				switch label.Size {
+				case 0, 1, 2, 4, 8:
+				default:
+					v.add(extend(labelPath, "size"), "%s: label %q of %q has size %d, but uint decoder needs 1, 2, 4 or 8 bytes", prefix, label.Name, owner, label.Size)
+				}
+			}
+		}
+	}
+}
+
+// keySize returns the number of bytes labels decode from a key
+func keySize(labels []Label) uint {
+	size := uint(0)
+	for _, label := range labels {
+		if !label.Reuse {
+			size += label.Size
+		}
+	}
+
+	return size
+}
+
+// sortedKeys returns keys of the map in order
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,707 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	known := func(name string) bool {
+		return name == "uint" || name == "ksym"
+	}
+
+	data := []byte(`programs:
+  - name: bio
+    metrics:
+      counters:
+        - name: bio_total
+          table: counts
+          sink_mod: 1
+          labels:
+            - name: dev
+              size: 4
+              decoders:
+                - name: majorminor
+      histograms:
+        - name: bio_latency
+          table: latency
+          bucket_type: exp2
+          bucket_min: 0
+          bucket_max: 0
+          labels: []
+        - name: bio_total
+          event: missing
+          bucket_type: linear
+          bucket_min: 0
+          bucket_max: 10
+          labels:
+            - name: bucket
+  - name: bio
+    metrics:
+      counters:
+        - name: sizes
+          table: sizes
+          labels:
+            - name: size
+              size: 3
+              decoders:
+                - name: uint
+        - name: sizes_by_function
+          table: sizes
+          labels:
+            - name: function
+              size: 8
+              decoders:
+                - name: ksym
+`)
+
+	problems, err := Validate(data, known)
+	if err != nil {
+		t.Fatalf("Error validating config: %s", err)
+	}
+
+	expected := []Problem{
+		{Line: 7, Message: `field sink_mod not found in type config.Counter`},
+		{Line: 12, Message: `program "bio": label "dev" of "bio_total" uses unknown decoder "majorminor"`},
+		{Line: 14, Message: `program "bio": histogram "bio_latency" needs at least one label for buckets`},
+		{Line: 18, Message: `program "bio": histogram "bio_latency" has zero size buckets: bucket_min and bucket_max are both 0`},
+		{Line: 20, Message: `program "bio": metric "bio_total" is already defined in program "bio"`},
+		{Line: 21, Message: `program "bio": metric "bio_total" refers to unknown event "missing"`},
+		{Line: 27, Message: `program "bio" is defined more than once`},
+		{Line: 34, Message: `program "bio": label "size" of "sizes" has size 3, but uint decoder needs 1, 2, 4 or 8 bytes`},
+		{Line: 40, Message: `program "bio": labels of table "sizes" add up to 8 bytes, but other metrics of the table have 3 bytes`},
+	}
+
+	if !reflect.DeepEqual(problems, expected) {
+		t.Errorf("Expected problems:")
+		for _, problem := range expected {
+			t.Errorf("  %s", problem)
+		}
+		t.Errorf("Got:")
+		for _, problem := range problems {
+			t.Errorf("  %s", problem)
+		}
+	}
+}
+
+func TestValidateValid(t *testing.T) {
+	data := []byte(`programs:
+  - name: timers
+    metrics:
+      counters:
+        - name: timer_start_total
+          table: counts
+          labels:
+            - name: function
+              size: 8
+              decoders:
+                - name: ksym
+`)
+
+	problems, err := Validate(data, func(string) bool { return true })
+	if err != nil {
+		t.Fatalf("Error validating config: %s", err)
+	}
+
+	if len(problems) != 0 {
+		t.Errorf("Expected no problems, got %v", problems)
+	}
+}
+
+func TestValidateConditions(t *testing.T) {
+	data := []byte(`programs:
+  - name: bio
+    kernel_version: "< 5.0"
+    metrics:
+      counters:
+        - name: bio_total
+          table: counts
+          labels: []
+  - name: bio
+    kernel_version: ">= 5.0, < 5.x"
+    metrics:
+      counters:
+        - name: bio_total
+          table: counts
+          labels: []
+    probes:
+      - requires:
+          tracepoints: [block_rq_issue]
+        kprobes:
+          blk_mq_start_request: trace_req_start
+  - name: bio
+`)
+
+	problems, err := Validate(data, func(string) bool { return true })
+	if err != nil {
+		t.Fatalf("Error validating config: %s", err)
+	}
+
+	expected := []Problem{
+		{Line: 10, Message: `program "bio": invalid kernel version constraint ">= 5.0, < 5.x": "5.x" is not a version`},
+		{Line: 18, Message: `program "bio": required tracepoint "block_rq_issue" is not in category:name form`},
+		{Line: 21, Message: `program "bio" is defined more than once`},
+	}
+
+	if !reflect.DeepEqual(problems, expected) {
+		t.Errorf("Expected problems %v, got %v", expected, problems)
+	}
+}
+
+func TestValidateTemplates(t *testing.T) {
+	data := []byte(`sink:
+  root: ${TEST_EXPORTER_MISSING}
+programs:
+  - name: bio
+    templates: true
+    cflags:
+      - -DMAX_DISKS={{.Env.MAX_DISKS}
+    code: |
+      int bio;
+  - name: plain
+    code: |
+      struct key k = {{0}};
+`)
+
+	problems, err := Validate(data, func(string) bool { return true })
+	if err != nil {
+		t.Fatalf("Error validating config: %s", err)
+	}
+
+	// Template parse errors differ between go versions
+	expected := []Problem{
+		{Line: 2, Message: `environment variable "TEST_EXPORTER_MISSING" is not set and has no default`},
+		{Line: 7, Message: `program "bio": error parsing cflags template: template: bio cflags:1: `},
+	}
+
+	if len(problems) != len(expected) {
+		t.Fatalf("Expected problems %v, got %v", expected, problems)
+	}
+
+	for i, problem := range problems {
+		if problem.Line != expected[i].Line || !strings.HasPrefix(problem.Message, expected[i].Message) {
+			t.Errorf("Expected problem %s, got %s", expected[i], problem)
+		}
+	}
+}
+
+func TestValidateExternalLabels(t *testing.T) {
+	data := []byte(`global:
+  external_labels:
+    cluster: prod
+    node_id: node-1
+    k8s_cluster: prod
+    k8scluster: prod
+    type: prod
+    Spec_Version: "1.0"
+  node_labels:
+    topology.zone: topology.kubernetes.io/zone
+    _: kubernetes.io/hostname
+programs:
+  - name: timers
+    metrics:
+      counters:
+        - name: timer_start_total
+          table: counts
+          labels:
+            - name: cluster
+              size: 8
+              decoders:
+                - name: uint
+`)
+
+	problems, err := Validate(data, func(string) bool { return true })
+	if err != nil {
+		t.Fatalf("Error validating config: %s", err)
+	}
+
+	expected := []Problem{
+		{Line: 8, Message: `external label "Spec_Version" would replace CloudEvents attribute "specversion"`},
+		{Line: 6, Message: `external labels "k8s_cluster" and "k8scluster" are both CloudEvents extension "k8scluster"`},
+		{Line: 4, Message: `external label "node_id" is reserved`},
+		{Line: 7, Message: `external label "type" would replace CloudEvents attribute "type"`},
+		{Line: 11, Message: `external label "_" is not a valid label name`},
+		{Line: 10, Message: `external label "topology.zone" is not a valid label name`},
+		{Line: 16, Message: `program "timers": label "cluster" of metric "timer_start_total" is also an external label`},
+	}
+
+	if !reflect.DeepEqual(problems, expected) {
+		t.Errorf("Expected problems %v, got %v", expected, problems)
+	}
+}
+
+func TestValidatePerfEvents(t *testing.T) {
+	data := []byte(`programs:
+  - name: llcstat
+    perf_events:
+      - type: hw_cache
+        name: llc-load-misses
+        target: on_cache_miss
+      - type: software
+        name: cpu-clock
+        target: on_cpu_clock
+        sample_frequency: 99
+      - type: software
+        name: missing
+`)
+
+	problems, err := Validate(data, func(string) bool { return true })
+	if err != nil {
+		t.Fatalf("Error validating config: %s", err)
+	}
+
+	expected := []Problem{
+		{Line: 4, Message: `program "llcstat": perf event hw_cache:llc-load-misses needs either sample_period or sample_frequency`},
+		{Line: 11, Message: `program "llcstat": unknown perf event name "missing" for type 1`},
+		{Line: 11, Message: `program "llcstat": perf event software:missing has no target`},
+		{Line: 11, Message: `program "llcstat": perf event software:missing needs either sample_period or sample_frequency`},
+	}
+
+	if !reflect.DeepEqual(problems, expected) {
+		t.Errorf("Expected problems %v, got %v", expected, problems)
+	}
+}
+
+func TestValidateEventLabels(t *testing.T) {
+	data := []byte(`programs:
+  - name: tcp
+    events:
+      - name: connects
+        table: connect_events
+        labels:
+          - name: port
+            size: 8
+            decoders:
+              - name: uint
+    metrics:
+      counters:
+        - name: connects_total
+          event: connects
+          labels:
+            - name: port
+            - name: pid
+`)
+
+	problems, err := Validate(data, func(string) bool { return true })
+	if err != nil {
+		t.Fatalf("Error validating config: %s", err)
+	}
+
+	expected := []Problem{
+		{Line: 17, Message: `program "tcp": label "pid" of metric "connects_total" is not decoded by event "connects"`},
+	}
+
+	if !reflect.DeepEqual(problems, expected) {
+		t.Errorf("Expected problems %v, got %v", expected, problems)
+	}
+}
+
+func TestValidateMaxSeries(t *testing.T) {
+	data := []byte(`programs:
+  - name: tcp
+    events:
+      - name: connects
+        table: connect_events
+        labels:
+          - name: port
+            size: 8
+            decoders:
+              - name: uint
+    metrics:
+      counters:
+        - name: connects_total
+          event: connects
+          max_series: 100
+          labels:
+            - name: port
+        - name: accepts_total
+          table: accepts
+          max_series: 100
+          labels:
+            - name: port
+              size: 8
+              decoders:
+                - name: uint
+`)
+
+	problems, err := Validate(data, func(string) bool { return true })
+	if err != nil {
+		t.Fatalf("Error validating config: %s", err)
+	}
+
+	expected := []Problem{
+		{Line: 20, Message: `program "tcp": metric "accepts_total" reads a table, max_series only limits metrics counted from events`},
+	}
+
+	if !reflect.DeepEqual(problems, expected) {
+		t.Errorf("Expected problems %v, got %v", expected, problems)
+	}
+}
+
+func TestValidateNamespaces(t *testing.T) {
+	data := []byte(`global:
+  namespace: node
+programs:
+  - name: bio
+    namespace: storage
+    metrics:
+      counters:
+        - name: requests_total
+          table: counts
+          labels: []
+  - name: tcp
+    metrics:
+      counters:
+        - name: requests_total
+          table: counts
+          labels: []
+  - name: udp
+    namespace: 1udp
+    metrics:
+      counters:
+        - name: requests_total
+          table: counts
+          labels: []
+  - name: sctp
+    namespace: node
+    metrics:
+      counters:
+        - name: requests_total
+          table: counts
+          labels: []
+`)
+
+	problems, err := Validate(data, func(string) bool { return true })
+	if err != nil {
+		t.Fatalf("Error validating config: %s", err)
+	}
+
+	expected := []Problem{
+		{Line: 18, Message: `program "udp": namespace "1udp" is not a valid metric name prefix`},
+		{Line: 28, Message: `program "sctp": metric "requests_total" is already defined in program "tcp"`},
+	}
+
+	if !reflect.DeepEqual(problems, expected) {
+		t.Errorf("Expected problems %v, got %v", expected, problems)
+	}
+}
+
+func TestValidateBucketTypes(t *testing.T) {
+	data := []byte(`programs:
+  - name: latency
+    metrics:
+      histograms:
+        - name: log_linear_seconds
+          table: log_linear
+          bucket_type: log-linear
+          bucket_min: 0
+          bucket_max: 40
+          bucket_sub_buckets: 4
+          labels:
+            - name: bucket
+              size: 8
+              decoders:
+                - name: uint
+        - name: log_linear_bad_seconds
+          table: log_linear_bad
+          bucket_type: log-linear
+          bucket_min: 0
+          bucket_max: 40
+          bucket_sub_buckets: 6
+          labels:
+            - name: bucket
+              size: 8
+              decoders:
+                - name: uint
+        - name: custom_seconds
+          table: custom
+          bucket_type: custom
+          bucket_min: 0
+          bucket_max: 3
+          bucket_boundaries: [100, 250, 500, 1000]
+          labels:
+            - name: bucket
+              size: 8
+              decoders:
+                - name: uint
+        - name: custom_bad_seconds
+          table: custom_bad
+          bucket_type: custom
+          bucket_min: 0
+          bucket_max: 3
+          bucket_boundaries: [100, 500, 250]
+          labels:
+            - name: bucket
+              size: 8
+              decoders:
+                - name: uint
+        - name: custom_empty_seconds
+          table: custom_empty
+          bucket_type: custom
+          bucket_min: 0
+          bucket_max: 3
+          labels:
+            - name: bucket
+              size: 8
+              decoders:
+                - name: uint
+`)
+
+	problems, err := Validate(data, func(string) bool { return true })
+	if err != nil {
+		t.Fatalf("Error validating config: %s", err)
+	}
+
+	expected := []Problem{
+		{Line: 21, Message: `program "latency": histogram "log_linear_bad_seconds" needs bucket_sub_buckets to be a power of two, got 6`},
+		{Line: 43, Message: `program "latency": histogram "custom_bad_seconds" has bucket boundary 250 not above the previous one 500`},
+		{Line: 42, Message: `program "latency": histogram "custom_bad_seconds" has bucket_max 3, but only 3 bucket_boundaries`},
+		{Line: 49, Message: `program "latency": histogram "custom_empty_seconds" with custom buckets needs bucket_boundaries`},
+	}
+
+	if !reflect.DeepEqual(problems, expected) {
+		t.Errorf("Expected problems %v, got %v", expected, problems)
+	}
+}
+
+func TestValidateSlots(t *testing.T) {
+	data := []byte(`programs:
+  - name: latency
+    metrics:
+      histograms:
+        - name: valid_seconds
+          table: valid
+          bucket_type: exp2
+          bucket_min: 1
+          bucket_max: 26
+          underflow_slot: 0
+          overflow_slot: 27
+          sum_slot: 28
+          labels:
+            - name: bucket
+              size: 8
+              decoders:
+                - name: uint
+        - name: inside_seconds
+          table: inside
+          bucket_type: exp2
+          bucket_min: 0
+          bucket_max: 26
+          overflow_slot: 26
+          labels:
+            - name: bucket
+              size: 8
+              decoders:
+                - name: uint
+        - name: shared_seconds
+          table: shared
+          bucket_type: exp2
+          bucket_min: 0
+          bucket_max: 26
+          overflow_slot: 27
+          labels:
+            - name: bucket
+              size: 8
+              decoders:
+                - name: uint
+        - name: native_seconds
+          table: native
+          bucket_type: exp2
+          bucket_min: 0
+          bucket_max: 26
+          native: true
+          overflow_slot: 28
+          labels:
+            - name: bucket
+              size: 8
+              decoders:
+                - name: uint
+`)
+
+	problems, err := Validate(data, func(string) bool { return true })
+	if err != nil {
+		t.Fatalf("Error validating config: %s", err)
+	}
+
+	expected := []Problem{
+		{Line: 23, Message: `program "latency": histogram "inside_seconds" has overflow_slot 26 inside of buckets [0 .. 26]`},
+		{Line: 34, Message: `program "latency": histogram "shared_seconds" has slot 27 as both overflow_slot and default sum slot, set sum_slot to another slot`},
+		{Line: 46, Message: `program "latency": native histogram "native_seconds" cannot have overflow_slot`},
+	}
+
+	if !reflect.DeepEqual(problems, expected) {
+		t.Errorf("Expected problems %v, got %v", expected, problems)
+	}
+}
+
+func TestValidateQuantiles(t *testing.T) {
+	data := []byte(`label_sets:
+  percentile:
+    - name: quantile
+      size: 8
+      decoders:
+        - name: uint
+programs:
+  - name: bio
+    metrics:
+      counters:
+        - name: bio_latency_seconds_quantile
+          table: counts
+          labels: []
+      histograms:
+        - name: bio_latency_seconds
+          table: latency
+          bucket_type: exp2
+          bucket_min: 0
+          bucket_max: 26
+          quantiles: [0.5, 1.5]
+          labels:
+            - name: quantile
+              size: 8
+              decoders:
+                - name: uint
+            - name: bucket
+              size: 8
+              decoders:
+                - name: uint
+        - name: bio_size_bytes
+          table: size
+          bucket_type: exp2
+          bucket_min: 0
+          bucket_max: 26
+          no_buckets: true
+          labels:
+            - name: bucket
+              size: 8
+              decoders:
+                - name: uint
+        - name: bio_queue_seconds
+          table: queue
+          bucket_type: exp2
+          bucket_min: 0
+          bucket_max: 26
+          quantiles: [0.5]
+          labels_from: percentile
+          labels:
+            - name: bucket
+              size: 8
+              decoders:
+                - name: uint
+`)
+
+	problems, err := Validate(data, func(string) bool { return true })
+	if err != nil {
+		t.Fatalf("Error validating config: %s", err)
+	}
+
+	expected := []Problem{
+		{Line: 15, Message: `program "bio": metric "bio_latency_seconds_quantile" is already defined in program "bio"`},
+		{Line: 20, Message: `program "bio": histogram "bio_latency_seconds" has quantile 1.5 outside of [0 .. 1]`},
+		{Line: 22, Message: `program "bio": label "quantile" of histogram "bio_latency_seconds" clashes with the label of quantiles`},
+		{Line: 35, Message: `program "bio": histogram "bio_size_bytes" has no_buckets, but no quantiles to export instead`},
+		{Line: 47, Message: `program "bio": label "quantile" of histogram "bio_queue_seconds" clashes with the label of quantiles`},
+	}
+
+	if !reflect.DeepEqual(problems, expected) {
+		t.Errorf("Expected problems %v, got %v", expected, problems)
+	}
+}
+
+func TestValidateExemplars(t *testing.T) {
+	data := []byte(`label_sets:
+  kube_pid:
+    - name: app_container
+      size: 8
+      decoders:
+        - name: uint
+programs:
+  - name: bio
+    metrics:
+      histograms:
+        - name: bio_latency_seconds
+          table: latency
+          bucket_type: exp2
+          bucket_min: 0
+          bucket_max: 26
+          labels:
+            - name: bucket
+              size: 8
+              decoders:
+                - name: uint
+          exemplars:
+            table: latency_exemplars
+            labels_from: kube_pid
+            labels:
+              - name: pid
+                size: 8
+                reuse: true
+                decoders:
+                  - name: uint
+        - name: bio_size_bytes
+          table: size
+          bucket_type: exp2
+          bucket_min: 0
+          bucket_max: 26
+          native: true
+          labels:
+            - name: bucket
+              size: 8
+              decoders:
+                - name: uint
+          exemplars:
+            labels:
+              - name: 1pid
+                size: 8
+                decoders:
+                  - name: uint
+`)
+
+	problems, err := Validate(data, func(string) bool { return true })
+	if err != nil {
+		t.Fatalf("Error validating config: %s", err)
+	}
+
+	expected := []Problem{
+		{Line: 42, Message: `program "bio": exemplars of histogram "bio_size_bytes" have no table`},
+		{Line: 42, Message: `program "bio": native histogram "bio_size_bytes" cannot have exemplars`},
+		{Line: 43, Message: `program "bio": exemplar label "1pid" of histogram "bio_size_bytes" is not a valid label name`},
+	}
+
+	if !reflect.DeepEqual(problems, expected) {
+		t.Errorf("Expected problems %v, got %v", expected, problems)
+	}
+}
+
+func TestValidateSink(t *testing.T) {
+	data := []byte(`sink:
+  compression_level: 10
+  value_mode: deltas
+  overflow_policy: drop-latest
+programs: []
+`)
+
+	problems, err := Validate(data, func(string) bool { return true })
+	if err != nil {
+		t.Fatalf("Error validating config: %s", err)
+	}
+
+	expected := []Problem{
+		{Line: 2, Message: `sink compression_level 10 is outside of [-2 .. 9]`},
+		{Line: 3, Message: `sink has unknown value_mode "deltas"`},
+		{Line: 4, Message: `sink has unknown overflow_policy "drop-latest"`},
+	}
+
+	if !reflect.DeepEqual(problems, expected) {
+		t.Errorf("Expected problems %v, got %v", expected, problems)
+	}
+
+	problems, err = Validate([]byte("sink:\n  compression_level: 0\nprograms: []\n"), func(string) bool { return true })
+	if err != nil {
+		t.Fatalf("Error validating config: %s", err)
+	}
+
+	if len(problems) != 0 {
+		t.Errorf("Expected no problems with compression_level 0, got %v", problems)
+	}
+}
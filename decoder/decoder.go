@@ -43,6 +43,12 @@ func NewSet() *Set {
 	}
 }
 
+// Known reports whether a decoder with the provided name exists
+func (s *Set) Known(name string) bool {
+	_, ok := s.decoders[name]
+	return ok
+}
+
 // Decode transforms input byte field into a string according to configuration
 func (s *Set) Decode(in []byte, label config.Label) ([]byte, error) {
 	result := in
@@ -0,0 +1,91 @@
+package exporter
+
+import (
+	"fmt"
+
+	"github.com/ahas-sigs/kube-ebpf-exporter/config"
+	"github.com/iovisor/gobpf/bcc"
+)
+
+// Check compiles every program and checks tables used by metrics and events
+// against the compiled code without attaching anything to the kernel,
+// variants of a program for different kernels are told apart by their kernel_version
+func Check(config config.Config) []error {
+	problems := []error{}
+
+	for _, program := range config.Programs {
+		name := fmt.Sprintf("%q", program.Name)
+		if program.KernelVersion != "" {
+			name = fmt.Sprintf("%q for kernel %q", program.Name, program.KernelVersion)
+		}
+
+		module := bcc.NewModule(program.Code, program.Cflags)
+		if module == nil {
+			problems = append(problems, fmt.Errorf("error compiling module for program %s", name))
+			continue
+		}
+
+		for _, counter := range program.Metrics.Counters {
+			if counter.Table == "" {
+				continue
+			}
+
+			if err := checkMetricTable(module, counter.Table, counter.Labels); err != nil {
+				problems = append(problems, fmt.Errorf("program %s: counter %q: %s", name, counter.Name, err))
+			}
+		}
+
+		for _, histogram := range program.Metrics.Histograms {
+			if histogram.Table == "" {
+				continue
+			}
+
+			if err := checkMetricTable(module, histogram.Table, histogram.Labels); err != nil {
+				problems = append(problems, fmt.Errorf("program %s: histogram %q: %s", name, histogram.Name, err))
+			}
+		}
+
+		// Keys of perf output tables are cpu numbers, labels decode records instead
+		for _, event := range program.Events {
+			if !tableExists(module, event.Table) {
+				problems = append(problems, fmt.Errorf("program %s: event %q: table %q is not found", name, event.Name, event.Table))
+			}
+		}
+
+		module.Close()
+	}
+
+	return problems
+}
+
+// checkMetricTable checks that the table exists and labels decode whole keys
+// and that values are wide enough to be read as counters
+func checkMetricTable(module *bcc.Module, tableName string, labels []config.Label) error {
+	if !tableExists(module, tableName) {
+		return fmt.Errorf("table %q is not found", tableName)
+	}
+
+	tableConfig := bcc.NewTable(module.TableId(tableName), module).Config()
+
+	size := uint64(0)
+	for _, label := range labels {
+		if !label.Reuse {
+			size += uint64(label.Size)
+		}
+	}
+
+	if keySize := tableConfig["key_size"].(uint64); keySize != size {
+		return fmt.Errorf("table %q has %d byte keys, but labels add up to %d bytes", tableName, keySize, size)
+	}
+
+	if leafSize := tableConfig["leaf_size"].(uint64); leafSize < 8 {
+		return fmt.Errorf("table %q has %d byte values, but 8 bytes are read", tableName, leafSize)
+	}
+
+	return nil
+}
+
+// tableExists reports whether the module has a table with the provided name
+func tableExists(module *bcc.Module, tableName string) bool {
+	return uint64(module.TableId(tableName)) < module.TableSize()
+}
@@ -0,0 +1,297 @@
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ahas-sigs/kube-ebpf-exporter/config"
+)
+
+const (
+	// cloudEventsSpecVersion is the version of CloudEvents spec we produce
+	cloudEventsSpecVersion = "1.0"
+	// cloudEventsContentType is the content type of data in all our events
+	cloudEventsContentType = "application/json"
+	// cloudEventsDefaultHTTPTimeout is used when http_timeout is not set
+	cloudEventsDefaultHTTPTimeout = 5 * time.Second
+	// cloudEventsQueueSize limits how many events wait for http delivery
+	cloudEventsQueueSize = 100
+
+	cloudEventTypeStart        = "ahas-sigs.cloudevents.kube-ebpf-exporter.start"
+	cloudEventTypeSinkRotate   = "ahas-sigs.cloudevents.kube-ebpf-exporter.sink.rotate"
+	cloudEventTypeSinkRecord   = "ahas-sigs.cloudevents.kube-ebpf-exporter.sink.record"
+	cloudEventTypeAttach       = "ahas-sigs.cloudevents.kube-ebpf-exporter.program.attach"
+	cloudEventTypeDetach       = "ahas-sigs.cloudevents.kube-ebpf-exporter.program.detach"
+	cloudEventTypeEvent        = "ahas-sigs.cloudevents.kube-ebpf-exporter.program.event"
+	cloudEventsDefaultFilePath = "/ahas-workspace/data/ahas/ahas-agent/ebpf-exporter/event.dat"
+)
+
+// cloudEvent is a CloudEvents 1.0 event, see:
+// * https://github.com/cloudevents/spec/blob/v1.0/spec.md
+type cloudEvent struct {
+	ID      string
+	Source  string
+	Type    string
+	Subject string
+	Time    time.Time
+	Data    interface{}
+	// Extensions are additional context attributes, names must
+	// only consist of lower-case letters and digits
+	Extensions map[string]string
+}
+
+// MarshalJSON encodes the event in structured content mode, see:
+// * https://github.com/cloudevents/spec/blob/v1.0/json-format.md
+func (c cloudEvent) MarshalJSON() ([]byte, error) {
+	event := make(map[string]interface{}, len(c.Extensions)+8)
+
+	for name, value := range c.Extensions {
+		event[name] = value
+	}
+
+	event["specversion"] = cloudEventsSpecVersion
+	event["id"] = c.ID
+	event["source"] = c.Source
+	event["type"] = c.Type
+	event["time"] = c.Time.Format(time.RFC3339Nano)
+	event["datacontenttype"] = cloudEventsContentType
+	event["data"] = c.Data
+
+	if c.Subject != "" {
+		event["subject"] = c.Subject
+	}
+
+	return json.Marshal(event)
+}
+
+// httpRequest builds a request carrying the event in binary content mode, see:
+// * https://github.com/cloudevents/spec/blob/v1.0/http-protocol-binding.md
+func (c cloudEvent) httpRequest(endpoint string) (*http.Request, error) {
+	data, err := json.Marshal(c.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", cloudEventsContentType)
+	req.Header.Set("ce-specversion", cloudEventsSpecVersion)
+	req.Header.Set("ce-id", c.ID)
+	req.Header.Set("ce-source", c.Source)
+	req.Header.Set("ce-type", c.Type)
+	req.Header.Set("ce-time", c.Time.Format(time.RFC3339Nano))
+
+	if c.Subject != "" {
+		req.Header.Set("ce-subject", c.Subject)
+	}
+
+	for name, value := range c.Extensions {
+		req.Header.Set("ce-"+name, value)
+	}
+
+	return req, nil
+}
+
+// addLabelExtensions adds external labels as extension attributes,
+// they replace node attributes with the same extension name
+func addLabelExtensions(extensions map[string]string, labels map[string]string) {
+	for name, value := range labels {
+		extensions[config.ExtensionName(name)] = value
+	}
+}
+
+// cloudEventer creates lifecycle and sink events for the exporter
+// and delivers lifecycle events to the configured destinations,
+// http delivery happens in the background, so that a slow endpoint
+// never holds up startup, attaching, rotation or shutdown
+type cloudEventer struct {
+	mu         sync.Mutex
+	config     config.CloudEvents
+	extensions map[string]string
+	client     *http.Client
+	idPrefix   string
+	idSeq      uint64
+	queue      chan cloudEvent
+	queueLock  sync.RWMutex
+	closed     bool
+	delivered  chan struct{}
+	dropped    uint64
+}
+
+// newCloudEventer creates a cloudEventer with the provided extensions
+// attached to every event it creates
+func newCloudEventer(conf config.CloudEvents, extensions map[string]string) *cloudEventer {
+	if conf.File == "" {
+		conf.File = cloudEventsDefaultFilePath
+	}
+
+	if conf.HTTPTimeout <= 0 {
+		conf.HTTPTimeout = cloudEventsDefaultHTTPTimeout
+	}
+
+	// Random prefix keeps ids unique across restarts
+	prefix := make([]byte, 8)
+	if _, err := rand.Read(prefix); err != nil {
+		binary.BigEndian.PutUint64(prefix, uint64(time.Now().UnixNano()))
+	}
+
+	c := &cloudEventer{
+		config:     conf,
+		extensions: extensions,
+		client:     &http.Client{Timeout: conf.HTTPTimeout},
+		idPrefix:   hex.EncodeToString(prefix),
+	}
+
+	if conf.HTTPEndpoint != "" {
+		c.queue = make(chan cloudEvent, cloudEventsQueueSize)
+		c.delivered = make(chan struct{})
+		go c.deliver()
+	}
+
+	return c
+}
+
+// newEvent creates an event with a unique id
+func (c *cloudEventer) newEvent(eventType string, subject string, t time.Time, data interface{}) cloudEvent {
+	return cloudEvent{
+		ID:         fmt.Sprintf("%s-%d", c.idPrefix, atomic.AddUint64(&c.idSeq, 1)),
+		Source:     c.config.Source,
+		Type:       eventType,
+		Subject:    subject,
+		Time:       t,
+		Data:       data,
+		Extensions: c.extensions,
+	}
+}
+
+// emit writes a lifecycle event to the event file and queues it for
+// the http endpoint if one is configured, dropping it if the queue is full
+func (c *cloudEventer) emit(eventType string, subject string, data interface{}) {
+	event := c.newEvent(eventType, subject, time.Now(), data)
+
+	if err := c.writeFile(event); err != nil {
+		log.Printf("write %s fail, %s", c.config.File, err)
+	}
+
+	if c.queue == nil {
+		return
+	}
+
+	c.queueLock.RLock()
+	defer c.queueLock.RUnlock()
+
+	if c.closed {
+		atomic.AddUint64(&c.dropped, 1)
+		log.Printf("drop cloud event %q for %s, delivery is closed", event.Type, c.config.HTTPEndpoint)
+		return
+	}
+
+	select {
+	case c.queue <- event:
+	default:
+		atomic.AddUint64(&c.dropped, 1)
+		log.Printf("drop cloud event %q for %s, delivery queue is full", event.Type, c.config.HTTPEndpoint)
+	}
+}
+
+// deliver posts queued events to the http endpoint one by one
+// until the queue is closed and drained
+func (c *cloudEventer) deliver() {
+	defer close(c.delivered)
+
+	for event := range c.queue {
+		if err := c.post(event); err != nil {
+			log.Printf("post cloud event %q to %s fail, %s", event.Type, c.config.HTTPEndpoint, err)
+		}
+	}
+}
+
+// close stops accepting events for the http endpoint and waits
+// for the queued ones to be delivered until the context is done
+func (c *cloudEventer) close(ctx context.Context) error {
+	if c.queue == nil {
+		return nil
+	}
+
+	c.queueLock.Lock()
+	if !c.closed {
+		c.closed = true
+		close(c.queue)
+	}
+	c.queueLock.Unlock()
+
+	select {
+	case <-c.delivered:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("error waiting for %d queued cloud events to be delivered: %s", len(c.queue), ctx.Err())
+	}
+}
+
+// writeFile appends an event in structured content mode to the event file
+func (c *cloudEventer) writeFile(event cloudEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err = os.MkdirAll(filepath.Dir(c.config.File), 0777); err != nil {
+		return err
+	}
+
+	fl, err := os.OpenFile(c.config.File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0777)
+	if err != nil {
+		return err
+	}
+
+	_, err = fl.Write(append(data, '\n'))
+
+	if cerr := fl.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// post sends an event in binary content mode to the http endpoint
+func (c *cloudEventer) post(event cloudEvent) error {
+	req, err := event.httpRequest(c.config.HTTPEndpoint)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %q", resp.Status)
+	}
+
+	return nil
+}
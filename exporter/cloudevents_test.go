@@ -0,0 +1,208 @@
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ahas-sigs/kube-ebpf-exporter/config"
+)
+
+func TestCloudEventStructured(t *testing.T) {
+	c := newCloudEventer(config.CloudEvents{Source: "/test"}, map[string]string{"node": "localhost"})
+
+	ts := time.Date(2020, 4, 14, 15, 0, 0, 0, time.UTC)
+
+	data, err := json.Marshal(c.newEvent(cloudEventTypeSinkRecord, "table", ts, map[string]interface{}{"value": 1}))
+	if err != nil {
+		t.Fatalf("Error marshaling event: %s", err)
+	}
+
+	event := map[string]interface{}{}
+	if err := json.Unmarshal(data, &event); err != nil {
+		t.Fatalf("Error unmarshaling event %s: %s", data, err)
+	}
+
+	expected := map[string]interface{}{
+		"specversion":     "1.0",
+		"source":          "/test",
+		"type":            cloudEventTypeSinkRecord,
+		"subject":         "table",
+		"time":            "2020-04-14T15:00:00Z",
+		"datacontenttype": "application/json",
+		"node":            "localhost",
+	}
+
+	for key, value := range expected {
+		if event[key] != value {
+			t.Errorf("Expected %q to be %v, got %v", key, value, event[key])
+		}
+	}
+
+	if event["id"] == "" || event["id"] == nil {
+		t.Errorf("Expected non-empty id in %s", data)
+	}
+
+	if event["data"].(map[string]interface{})["value"] != float64(1) {
+		t.Errorf("Expected data value to be 1, got %v", event["data"])
+	}
+}
+
+func TestCloudEventEmit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cloudevents")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	received := make(chan *http.Request, 1)
+	body := make(chan []byte, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		received <- r
+		body <- data
+	}))
+	defer server.Close()
+
+	file := filepath.Join(dir, "event.dat")
+
+	c := newCloudEventer(config.CloudEvents{Source: "/test", File: file, HTTPEndpoint: server.URL}, map[string]string{"node": "localhost"})
+
+	c.emit(cloudEventTypeStart, "", map[string]string{"hello": "world"})
+
+	r := <-received
+
+	for header, value := range map[string]string{
+		"Content-Type":   "application/json",
+		"ce-specversion": "1.0",
+		"ce-source":      "/test",
+		"ce-type":        cloudEventTypeStart,
+		"ce-node":        "localhost",
+	} {
+		if r.Header.Get(header) != value {
+			t.Errorf("Expected header %q to be %q, got %q", header, value, r.Header.Get(header))
+		}
+	}
+
+	if data := <-body; string(data) != `{"hello":"world"}` {
+		t.Errorf("Expected body to be data only, got %s", data)
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatalf("Error reading event file: %s", err)
+	}
+
+	event := map[string]interface{}{}
+	if err := json.Unmarshal(data, &event); err != nil {
+		t.Fatalf("Error unmarshaling event %s: %s", data, err)
+	}
+
+	if event["type"] != cloudEventTypeStart {
+		t.Errorf("Expected type %q in event file, got %v", cloudEventTypeStart, event["type"])
+	}
+}
+
+func TestAddLabelExtensions(t *testing.T) {
+	extensions := map[string]string{"node": "localhost", "cluster": "default"}
+
+	addLabelExtensions(extensions, map[string]string{"k8s_cluster": "prod", "Cluster": "staging"})
+
+	expected := map[string]string{"node": "localhost", "cluster": "staging", "k8scluster": "prod"}
+	if !reflect.DeepEqual(extensions, expected) {
+		t.Errorf("Expected extensions %v, got %v", expected, extensions)
+	}
+}
+
+func TestCloudEventEmitSlowEndpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cloudevents")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	release := make(chan struct{})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	file := filepath.Join(dir, "event.dat")
+
+	c := newCloudEventer(config.CloudEvents{Source: "/test", File: file, HTTPEndpoint: server.URL}, nil)
+
+	start := time.Now()
+
+	for i := 0; i < cloudEventsQueueSize+10; i++ {
+		c.emit(cloudEventTypeAttach, "bio", nil)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected emit not to wait for the endpoint, took %s", elapsed)
+	}
+
+	// One event is being posted, the queue is full and the rest is dropped
+	if dropped := atomic.LoadUint64(&c.dropped); dropped < 9 {
+		t.Errorf("Expected at least 9 dropped events, got %d", dropped)
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatalf("Error reading event file: %s", err)
+	}
+
+	if lines := strings.Count(string(data), "\n"); lines != cloudEventsQueueSize+10 {
+		t.Errorf("Expected %d events in event file, got %d", cloudEventsQueueSize+10, lines)
+	}
+}
+
+func TestCloudEventClose(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cloudevents")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	received := uint64(0)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+		atomic.AddUint64(&received, 1)
+	}))
+	defer server.Close()
+
+	c := newCloudEventer(config.CloudEvents{Source: "/test", File: filepath.Join(dir, "event.dat"), HTTPEndpoint: server.URL}, nil)
+
+	for i := 0; i < 5; i++ {
+		c.emit(cloudEventTypeDetach, "bio", nil)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := c.close(ctx); err != nil {
+		t.Fatalf("Error closing cloud eventer: %s", err)
+	}
+
+	if got := atomic.LoadUint64(&received); got != 5 {
+		t.Errorf("Expected all 5 queued events to be delivered on close, got %d", got)
+	}
+
+	c.emit(cloudEventTypeDetach, "bio", nil)
+
+	if dropped := atomic.LoadUint64(&c.dropped); dropped != 1 {
+		t.Errorf("Expected event emitted after close to be dropped, got %d dropped", dropped)
+	}
+}
@@ -0,0 +1,94 @@
+package exporter
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// sinkDelta is a change of a table value over a collection interval
+type sinkDelta struct {
+	labels []string
+	value  float64
+	start  time.Time
+	end    time.Time
+	// reset is true when the value went down since the previous
+	// collection, which means the counter was reset in the kernel
+	reset bool
+}
+
+// deltaTable is the previous snapshot of a single table
+type deltaTable struct {
+	time   time.Time
+	values map[string]float64
+}
+
+// deltaTracker keeps previous snapshots of tables to turn cumulative
+// table values into per-interval deltas
+type deltaTracker struct {
+	mu     sync.Mutex
+	tables map[string]*deltaTable
+}
+
+// newDeltaTracker creates an empty deltaTracker
+func newDeltaTracker() *deltaTracker {
+	return &deltaTracker{
+		tables: map[string]*deltaTable{},
+	}
+}
+
+// deltas returns changes of table values since the previous snapshot of the
+// same table and replaces the snapshot with the current values. The first
+// snapshot of a table only sets the baseline and produces no deltas.
+func (d *deltaTracker) deltas(table string, values []metricValue, now time.Time) []sinkDelta {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	current := make(map[string]float64, len(values))
+	for _, mv := range values {
+		current[deltaKey(mv)] = mv.value
+	}
+
+	previous, ok := d.tables[table]
+	d.tables[table] = &deltaTable{time: now, values: current}
+
+	if !ok {
+		return nil
+	}
+
+	deltas := []sinkDelta{}
+
+	for _, mv := range values {
+		// Keys missing from the previous snapshot were created in the kernel
+		// during the interval, so everything they have counted is new
+		delta := sinkDelta{
+			labels: mv.labels,
+			value:  mv.value,
+			start:  previous.time,
+			end:    now,
+		}
+
+		if prev, ok := previous.values[deltaKey(mv)]; ok {
+			if mv.value >= prev {
+				delta.value = mv.value - prev
+			} else {
+				delta.reset = true
+			}
+		}
+
+		deltas = append(deltas, delta)
+	}
+
+	return deltas
+}
+
+// deltaKey identifies the value in snapshots by the raw kernel key, since
+// many kernel keys can decode into the same labels, for example pids of
+// one container. Values of events have no kernel key and unique labels.
+func deltaKey(mv metricValue) string {
+	if mv.key != nil {
+		return string(mv.key)
+	}
+
+	return fmt.Sprintf("%#v", mv.labels)
+}
@@ -0,0 +1,87 @@
+package exporter
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDeltaTracker(t *testing.T) {
+	d := newDeltaTracker()
+
+	start := time.Date(2020, 4, 14, 15, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		now    time.Time
+		values []metricValue
+		deltas []sinkDelta
+	}{
+		{
+			now: start,
+			values: []metricValue{
+				{labels: []string{"a"}, value: 10},
+			},
+			deltas: nil,
+		},
+		{
+			now: start.Add(time.Minute),
+			values: []metricValue{
+				{labels: []string{"a"}, value: 15},
+				{labels: []string{"b"}, value: 3},
+			},
+			deltas: []sinkDelta{
+				{labels: []string{"a"}, value: 5, start: start, end: start.Add(time.Minute)},
+				{labels: []string{"b"}, value: 3, start: start, end: start.Add(time.Minute)},
+			},
+		},
+		{
+			now: start.Add(2 * time.Minute),
+			values: []metricValue{
+				{labels: []string{"a"}, value: 4},
+				{labels: []string{"b"}, value: 3},
+			},
+			deltas: []sinkDelta{
+				{labels: []string{"a"}, value: 4, start: start.Add(time.Minute), end: start.Add(2 * time.Minute), reset: true},
+				{labels: []string{"b"}, value: 0, start: start.Add(time.Minute), end: start.Add(2 * time.Minute)},
+			},
+		},
+	}
+
+	for i, c := range cases {
+		deltas := d.deltas("program/table", c.values, c.now)
+		if !reflect.DeepEqual(deltas, c.deltas) {
+			t.Errorf("Expected deltas %#v in case %d, got %#v", c.deltas, i, deltas)
+		}
+	}
+
+	if deltas := d.deltas("program/other", []metricValue{{labels: []string{"a"}, value: 1}}, start); deltas != nil {
+		t.Errorf("Expected no deltas for the first snapshot of another table, got %#v", deltas)
+	}
+}
+
+func TestDeltaTrackerSameLabels(t *testing.T) {
+	d := newDeltaTracker()
+
+	start := time.Date(2020, 4, 14, 15, 0, 0, 0, time.UTC)
+
+	// Pids 1 and 2 of the same container decode into the same labels
+	snapshot := func(first, second float64) []metricValue {
+		return []metricValue{
+			{key: []byte{1}, labels: []string{"app"}, value: first},
+			{key: []byte{2}, labels: []string{"app"}, value: second},
+		}
+	}
+
+	d.deltas("program/metric/table", snapshot(10, 100), start)
+
+	deltas := d.deltas("program/metric/table", snapshot(12, 105), start.Add(time.Minute))
+
+	expected := []sinkDelta{
+		{labels: []string{"app"}, value: 2, start: start, end: start.Add(time.Minute)},
+		{labels: []string{"app"}, value: 5, start: start, end: start.Add(time.Minute)},
+	}
+
+	if !reflect.DeepEqual(deltas, expected) {
+		t.Errorf("Expected deltas %#v, got %#v", expected, deltas)
+	}
+}
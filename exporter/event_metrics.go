@@ -0,0 +1,205 @@
+package exporter
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ahas-sigs/kube-ebpf-exporter/config"
+)
+
+// eventMetricDefaultMaxSeries is used when max_series is not set, it matches
+// the default size of BPF_HASH, which limits series of table-backed metrics
+const eventMetricDefaultMaxSeries = 10240
+
+// eventSeries picks metric labels out of decoded event labels and
+// limits the number of label sets a metric can have
+type eventSeries struct {
+	mu        sync.Mutex
+	program   string
+	metric    string
+	indexes   []int
+	maxSeries int
+	dropped   uint64
+}
+
+// newEventSeries maps metric labels to event labels by name
+func newEventSeries(programName string, metricName string, labels []config.Label, event config.Event, maxSeries int) (*eventSeries, error) {
+	positions := map[string]int{}
+	for idx, label := range event.Labels {
+		positions[label.Name] = idx
+	}
+
+	indexes := make([]int, len(labels))
+	for i, label := range labels {
+		idx, ok := positions[label.Name]
+		if !ok {
+			return nil, fmt.Errorf("label %q of metric %q is not decoded by event %q", label.Name, metricName, event.Name)
+		}
+
+		indexes[i] = idx
+	}
+
+	if maxSeries <= 0 {
+		maxSeries = eventMetricDefaultMaxSeries
+	}
+
+	return &eventSeries{
+		program:   programName,
+		metric:    metricName,
+		indexes:   indexes,
+		maxSeries: maxSeries,
+	}, nil
+}
+
+// pick returns metric label values out of event label values
+func (s *eventSeries) pick(values []string) []string {
+	labels := make([]string, len(s.indexes))
+	for i, idx := range s.indexes {
+		labels[i] = values[idx]
+	}
+
+	return labels
+}
+
+// eventCounter counts decoded event records by label set
+type eventCounter struct {
+	*eventSeries
+	values map[string]*metricValue
+}
+
+// newEventCounter creates a counter backed by the event
+func newEventCounter(programName string, counter config.Counter, event config.Event) (*eventCounter, error) {
+	series, err := newEventSeries(programName, counter.Name, counter.Labels, event, counter.MaxSeries)
+	if err != nil {
+		return nil, err
+	}
+
+	return &eventCounter{
+		eventSeries: series,
+		values:      map[string]*metricValue{},
+	}, nil
+}
+
+// observe counts a record with the provided decoded event labels
+func (c *eventCounter) observe(values []string) {
+	labels := c.pick(values)
+	key := fmt.Sprintf("%#v", labels)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.values[key]; !ok {
+		if len(c.values) >= c.maxSeries {
+			atomic.AddUint64(&c.dropped, 1)
+			return
+		}
+
+		c.values[key] = &metricValue{labels: labels}
+	}
+
+	c.values[key].value++
+}
+
+// snapshot returns current counter values
+func (c *eventCounter) snapshot() []metricValue {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	values := make([]metricValue, 0, len(c.values))
+	for _, value := range c.values {
+		values = append(values, *value)
+	}
+
+	return values
+}
+
+// eventHistogram puts values of decoded event records into histogram
+// buckets by label set, where the last label of the histogram is the value
+type eventHistogram struct {
+	*eventSeries
+	config     config.Histogram
+	histograms map[string]histogramWithLabels
+}
+
+// newEventHistogram creates a histogram backed by the event
+func newEventHistogram(programName string, histogram config.Histogram, event config.Event) (*eventHistogram, error) {
+	series, err := newEventSeries(programName, histogram.Name, histogram.Labels, event, histogram.MaxSeries)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := histogramSlot(0, histogram); err != nil {
+		return nil, err
+	}
+
+	return &eventHistogram{
+		eventSeries: series,
+		config:      histogram,
+		histograms:  map[string]histogramWithLabels{},
+	}, nil
+}
+
+// observe puts the value of a record with the provided decoded
+// event labels into its bucket
+func (h *eventHistogram) observe(values []string) error {
+	labels := h.pick(values)
+
+	value, err := strconv.ParseFloat(labels[len(labels)-1], 64)
+	if err != nil {
+		return fmt.Errorf("error parsing value %q for histogram %q: %s", labels[len(labels)-1], h.metric, err)
+	}
+
+	slot, err := histogramSlot(value, h.config)
+	if err != nil {
+		return err
+	}
+
+	labels = labels[0 : len(labels)-1]
+	key := fmt.Sprintf("%#v", labels)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.histograms[key]; !ok {
+		if len(h.histograms) >= h.maxSeries {
+			atomic.AddUint64(&h.dropped, 1)
+			return nil
+		}
+
+		h.histograms[key] = histogramWithLabels{
+			labels:  labels,
+			buckets: map[float64]uint64{},
+		}
+	}
+
+	buckets := h.histograms[key].buckets
+	buckets[slot]++
+
+	// Sum key, same as eBPF programs maintain it
+	buckets[float64(h.config.SumKey())] += uint64(value)
+
+	return nil
+}
+
+// snapshot returns copies of current histograms
+func (h *eventHistogram) snapshot() map[string]histogramWithLabels {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	histograms := make(map[string]histogramWithLabels, len(h.histograms))
+	for key, histogram := range h.histograms {
+		buckets := make(map[float64]uint64, len(histogram.buckets))
+		for bucket, count := range histogram.buckets {
+			buckets[bucket] = count
+		}
+
+		histograms[key] = histogramWithLabels{
+			labels:  histogram.labels,
+			buckets: buckets,
+		}
+	}
+
+	return histograms
+}
@@ -0,0 +1,189 @@
+package exporter
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/ahas-sigs/kube-ebpf-exporter/config"
+)
+
+var testEvent = config.Event{
+	Name: "connect_errors",
+	Labels: []config.Label{
+		{Name: "pid"},
+		{Name: "errno"},
+		{Name: "latency"},
+	},
+}
+
+func TestEventCounter(t *testing.T) {
+	counter, err := newEventCounter("tcp", config.Counter{
+		Name:      "errors_total",
+		Labels:    []config.Label{{Name: "errno"}},
+		MaxSeries: 2,
+	}, testEvent)
+	if err != nil {
+		t.Fatalf("Error creating counter: %s", err)
+	}
+
+	for _, values := range [][]string{
+		{"1", "111", "5"},
+		{"2", "111", "5"},
+		{"3", "113", "5"},
+		{"4", "110", "5"},
+	} {
+		counter.observe(values)
+	}
+
+	values := counter.snapshot()
+	sort.Slice(values, func(i, j int) bool {
+		return values[i].labels[0] < values[j].labels[0]
+	})
+
+	expected := []metricValue{
+		{labels: []string{"111"}, value: 2},
+		{labels: []string{"113"}, value: 1},
+	}
+
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("Expected values %#v, got %#v", expected, values)
+	}
+
+	if counter.dropped != 1 {
+		t.Errorf("Expected 1 dropped record, got %d", counter.dropped)
+	}
+}
+
+func TestEventCounterUnknownLabel(t *testing.T) {
+	_, err := newEventCounter("tcp", config.Counter{
+		Name:   "errors_total",
+		Labels: []config.Label{{Name: "comm"}},
+	}, testEvent)
+	if err == nil {
+		t.Errorf("Expected error for label missing from event")
+	}
+}
+
+func TestEventHistogram(t *testing.T) {
+	histogram, err := newEventHistogram("tcp", config.Histogram{
+		Name:       "latency",
+		BucketType: config.HistogramBucketExp2,
+		BucketMin:  0,
+		BucketMax:  3,
+		Labels:     []config.Label{{Name: "errno"}, {Name: "latency"}},
+	}, testEvent)
+	if err != nil {
+		t.Fatalf("Error creating histogram: %s", err)
+	}
+
+	for _, values := range [][]string{
+		{"1", "111", "0"},
+		{"1", "111", "3"},
+		{"1", "111", "100"},
+	} {
+		if err := histogram.observe(values); err != nil {
+			t.Fatalf("Error observing %v: %s", values, err)
+		}
+	}
+
+	if err := histogram.observe([]string{"1", "111", "fast"}); err == nil {
+		t.Errorf("Expected error observing non-numeric value")
+	}
+
+	histograms := histogram.snapshot()
+	if len(histograms) != 1 {
+		t.Fatalf("Expected 1 histogram, got %d", len(histograms))
+	}
+
+	for _, h := range histograms {
+		expected := map[float64]uint64{0: 1, 2: 1, 3: 1, 4: 103}
+		if !reflect.DeepEqual(h.buckets, expected) {
+			t.Errorf("Expected buckets %#v, got %#v", expected, h.buckets)
+		}
+
+		if !reflect.DeepEqual(h.labels, []string{"111"}) {
+			t.Errorf("Expected labels [111], got %#v", h.labels)
+		}
+	}
+}
+
+func TestHistogramSlot(t *testing.T) {
+	cases := []struct {
+		bucketType config.HistogramBucketType
+		value      float64
+		slot       float64
+	}{
+		{config.HistogramBucketExp2, 0, 0},
+		{config.HistogramBucketExp2, 1, 1},
+		{config.HistogramBucketExp2, 3, 2},
+		{config.HistogramBucketExp2, 4, 3},
+		{config.HistogramBucketExp2, 1 << 20, 10},
+		{config.HistogramBucketLinear, 2.5, 3},
+		{config.HistogramBucketLinear, 7, 7},
+		{config.HistogramBucketLinear, 20, 10},
+		{config.HistogramBucketLogLinear, 0, 0},
+		{config.HistogramBucketLogLinear, 5, 5},
+		{config.HistogramBucketLogLinear, 9, 8},
+		{config.HistogramBucketLogLinear, 11.5, 10},
+		{config.HistogramBucketLogLinear, 1000, 10},
+		{config.HistogramBucketCustom, 0, 0},
+		{config.HistogramBucketCustom, 2, 1},
+		{config.HistogramBucketCustom, 2.5, 1},
+		{config.HistogramBucketCustom, 6, 3},
+		{config.HistogramBucketCustom, 100, 10},
+	}
+
+	for _, c := range cases {
+		histogram := config.Histogram{
+			BucketType:       c.bucketType,
+			BucketMin:        0,
+			BucketMax:        10,
+			BucketSubBuckets: 4,
+			BucketBoundaries: []float64{1, 2.5, 5, 10, 20, 30, 40, 50, 60, 70, 80},
+		}
+
+		slot, err := histogramSlot(c.value, histogram)
+		if err != nil {
+			t.Fatalf("Error getting slot for %v: %s", c.value, err)
+		}
+
+		if slot != c.slot {
+			t.Errorf("Expected slot %v for %s value %v, got %v", c.slot, c.bucketType, c.value, slot)
+		}
+	}
+}
+
+func TestHistogramSlotOutsideOfBuckets(t *testing.T) {
+	underflow, overflow := 0, 11
+
+	histogram := config.Histogram{
+		BucketType:    config.HistogramBucketExp2,
+		BucketMin:     2,
+		BucketMax:     10,
+		UnderflowSlot: &underflow,
+		OverflowSlot:  &overflow,
+	}
+
+	cases := []struct {
+		value float64
+		slot  float64
+	}{
+		{0, 0},
+		{1, 0},
+		{2, 2},
+		{1 << 9, 10},
+		{1 << 10, 11},
+	}
+
+	for _, c := range cases {
+		slot, err := histogramSlot(c.value, histogram)
+		if err != nil {
+			t.Fatalf("Error getting slot for %v: %s", c.value, err)
+		}
+
+		if slot != c.slot {
+			t.Errorf("Expected slot %v for value %v, got %v", c.slot, c.value, slot)
+		}
+	}
+}
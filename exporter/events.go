@@ -0,0 +1,336 @@
+package exporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ahas-sigs/kube-ebpf-exporter/config"
+	"github.com/ahas-sigs/kube-ebpf-exporter/decoder"
+	"github.com/iovisor/gobpf/bcc"
+)
+
+const (
+	// eventQueueSize is the number of raw records buffered between
+	// perf buffer readers and the decoding goroutine of an event
+	eventQueueSize = 1024
+	// eventSinkBatchSize is the max number of event records in a sink batch
+	eventSinkBatchSize = 1000
+	// eventSinkBatchInterval is how often partial batches of event records are sinked
+	eventSinkBatchInterval = time.Second
+	// eventSubscriberBufferSize is the number of records buffered for each
+	// /events client, records that do not fit are dropped
+	eventSubscriberBufferSize = 1024
+)
+
+// eventStream reads and decodes records from a perf output table
+type eventStream struct {
+	program    string
+	config     config.Event
+	size       uint
+	perfMap    *bcc.PerfMap
+	records    chan []byte
+	done       chan struct{}
+	stopped    chan struct{}
+	received   uint64
+	counters   []*eventCounter
+	histograms []*eventHistogram
+}
+
+// startEventStream opens perf buffers for the table of the event
+// and starts decoding records coming from them, decoded records
+// are also counted by the provided event-backed metrics
+func (e *Exporter) startEventStream(programName string, module *bcc.Module, event config.Event, counters []*eventCounter, histograms []*eventHistogram) (*eventStream, error) {
+	size := uint(0)
+	for _, label := range event.Labels {
+		if !label.Reuse {
+			size += label.Size
+		}
+	}
+
+	table := bcc.NewTable(module.TableId(event.Table), module)
+	records := make(chan []byte, eventQueueSize)
+
+	perfMap, err := bcc.InitPerfMap(table, records)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open perf output table %q for event %q: %s", event.Table, event.Name, err)
+	}
+
+	stream := &eventStream{
+		program:    programName,
+		config:     event,
+		size:       size,
+		perfMap:    perfMap,
+		records:    records,
+		done:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+		counters:   counters,
+		histograms: histograms,
+	}
+
+	perfMap.Start()
+
+	go e.readEvents(stream)
+
+	return stream, nil
+}
+
+// stop stops polling perf buffers and waits for decoding to finish
+func (s *eventStream) stop() {
+	s.perfMap.Stop()
+	close(s.done)
+	<-s.stopped
+}
+
+// readEvents decodes records of the event stream and passes them to the sink
+// and /events subscribers until the stream is stopped
+func (e *Exporter) readEvents(stream *eventStream) {
+	defer close(stream.stopped)
+
+	ticker := time.NewTicker(eventSinkBatchInterval)
+	defer ticker.Stop()
+
+	batch := []string{}
+
+	flush := func() {
+		if len(batch) > 0 {
+			e.enqueueSinkValues(batch)
+			batch = []string{}
+		}
+	}
+
+	handle := func(raw []byte) {
+		values, err := e.decodeEventLabels(stream, raw)
+		if err != nil {
+			if err != decoder.ErrSkipLabelSet {
+				log.Printf("Error decoding record for event %q of program %q: %s", stream.config.Name, stream.program, err)
+			}
+			return
+		}
+
+		atomic.AddUint64(&stream.received, 1)
+
+		for _, counter := range stream.counters {
+			counter.observe(values)
+		}
+
+		for _, histogram := range stream.histograms {
+			if err := histogram.observe(values); err != nil {
+				log.Printf("Error observing record for event %q of program %q: %s", stream.config.Name, stream.program, err)
+			}
+		}
+
+		if !stream.config.Sink && !stream.config.Stream {
+			return
+		}
+
+		record, err := e.eventRecord(stream, values)
+		if err != nil {
+			log.Printf("Error encoding record for event %q of program %q: %s", stream.config.Name, stream.program, err)
+			return
+		}
+
+		if stream.config.Sink {
+			batch = append(batch, record)
+			if len(batch) >= eventSinkBatchSize {
+				flush()
+			}
+		}
+
+		if stream.config.Stream {
+			e.eventSubscribers.publish(stream.program, stream.config.Name, record)
+		}
+	}
+
+	for {
+		select {
+		case raw := <-stream.records:
+			handle(raw)
+		case <-ticker.C:
+			flush()
+		case <-stream.done:
+			// Records already read from perf buffers are handled before stopping
+			for drained := false; !drained; {
+				select {
+				case raw := <-stream.records:
+					handle(raw)
+				default:
+					drained = true
+				}
+			}
+
+			flush()
+			return
+		}
+	}
+}
+
+// decodeEventLabels decodes a raw record into label values
+func (e *Exporter) decodeEventLabels(stream *eventStream, raw []byte) ([]string, error) {
+	// Records may have trailing padding added by the compiler
+	if uint(len(raw)) < stream.size {
+		return nil, fmt.Errorf("record is %d bytes, but labels need %d bytes", len(raw), stream.size)
+	}
+
+	return e.decoders.DecodeLabels(raw[:stream.size], stream.config.Labels)
+}
+
+// eventRecord encodes decoded label values into a CloudEvents JSON line
+func (e *Exporter) eventRecord(stream *eventStream, values []string) (string, error) {
+	labels := make(map[string]string, len(values))
+	for idx, label := range stream.config.Labels {
+		labels[label.Name] = values[idx]
+	}
+
+	event := e.events.newEvent(cloudEventTypeEvent, stream.config.Name, time.Now(), map[string]interface{}{
+		"program": stream.program,
+		"event":   stream.config.Name,
+		"labels":  labels,
+	})
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s\n", data), nil
+}
+
+// eventSubscriber is an /events client waiting for records
+type eventSubscriber struct {
+	program string
+	event   string
+	records chan string
+}
+
+// eventSubscribers keeps /events clients and passes records to them
+type eventSubscribers struct {
+	mu          sync.Mutex
+	closed      bool
+	subscribers map[*eventSubscriber]struct{}
+	dropped     uint64
+}
+
+// newEventSubscribers creates an empty set of subscribers
+func newEventSubscribers() *eventSubscribers {
+	return &eventSubscribers{
+		subscribers: map[*eventSubscriber]struct{}{},
+	}
+}
+
+// subscribe adds a subscriber for records of the program and event,
+// where empty program or event match any of them
+func (s *eventSubscribers) subscribe(program, event string) (*eventSubscriber, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, fmt.Errorf("event streaming is stopped")
+	}
+
+	subscriber := &eventSubscriber{
+		program: program,
+		event:   event,
+		records: make(chan string, eventSubscriberBufferSize),
+	}
+
+	s.subscribers[subscriber] = struct{}{}
+
+	return subscriber, nil
+}
+
+// unsubscribe removes the subscriber
+func (s *eventSubscribers) unsubscribe(subscriber *eventSubscriber) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.subscribers[subscriber]; ok {
+		delete(s.subscribers, subscriber)
+		close(subscriber.records)
+	}
+}
+
+// publish passes the record to matching subscribers without blocking
+func (s *eventSubscribers) publish(program, event, record string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for subscriber := range s.subscribers {
+		if subscriber.program != "" && subscriber.program != program {
+			continue
+		}
+
+		if subscriber.event != "" && subscriber.event != event {
+			continue
+		}
+
+		select {
+		case subscriber.records <- record:
+		default:
+			atomic.AddUint64(&s.dropped, 1)
+		}
+	}
+}
+
+// close disconnects all subscribers and rejects new ones
+func (s *eventSubscribers) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+
+	for subscriber := range s.subscribers {
+		delete(s.subscribers, subscriber)
+		close(subscriber.records)
+	}
+}
+
+// EventsHandler streams decoded event records as newline delimited JSON,
+// optionally filtered with program and event query parameters
+func (e *Exporter) EventsHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	subscriber, err := e.eventSubscribers.subscribe(r.URL.Query().Get("program"), r.URL.Query().Get("event"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	defer e.eventSubscribers.unsubscribe(subscriber)
+
+	w.Header().Add("Content-type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case record, ok := <-subscriber.records:
+			if !ok {
+				return
+			}
+
+			if _, err := w.Write([]byte(record)); err != nil {
+				log.Printf("Error streaming events to client %q: %s", r.RemoteAddr, err)
+				return
+			}
+
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// StopEventsHandler disconnects all /events clients, so that http server
+// shutdown does not wait for them
+func (e *Exporter) StopEventsHandler() {
+	e.eventSubscribers.close()
+}
@@ -0,0 +1,157 @@
+package exporter
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ahas-sigs/kube-ebpf-exporter/config"
+	"github.com/ahas-sigs/kube-ebpf-exporter/decoder"
+	"github.com/iovisor/gobpf/bcc"
+)
+
+func TestDecodeEvent(t *testing.T) {
+	e := &Exporter{
+		decoders: decoder.NewSet(),
+		events:   newCloudEventer(config.CloudEvents{Source: "/test"}, nil),
+	}
+
+	stream := &eventStream{
+		program: "tcp",
+		size:    8,
+		config: config.Event{
+			Name: "connect_errors",
+			Labels: []config.Label{
+				{Name: "pid", Size: 4, Decoders: []config.Decoder{{Name: "uint"}}},
+				{Name: "errno", Size: 4, Decoders: []config.Decoder{{Name: "uint"}}},
+			},
+		},
+	}
+
+	// Trailing padding is ignored
+	raw := make([]byte, 12)
+	bcc.GetHostByteOrder().PutUint32(raw[0:4], 42)
+	bcc.GetHostByteOrder().PutUint32(raw[4:8], 111)
+
+	values, err := e.decodeEventLabels(stream, raw)
+	if err != nil {
+		t.Fatalf("Error decoding event: %s", err)
+	}
+
+	record, err := e.eventRecord(stream, values)
+	if err != nil {
+		t.Fatalf("Error encoding event: %s", err)
+	}
+
+	event := struct {
+		Type    string `json:"type"`
+		Subject string `json:"subject"`
+		Data    struct {
+			Program string            `json:"program"`
+			Event   string            `json:"event"`
+			Labels  map[string]string `json:"labels"`
+		} `json:"data"`
+	}{}
+
+	if err := json.Unmarshal([]byte(record), &event); err != nil {
+		t.Fatalf("Error unmarshaling record %s: %s", record, err)
+	}
+
+	if event.Type != cloudEventTypeEvent || event.Subject != "connect_errors" || event.Data.Program != "tcp" {
+		t.Errorf("Unexpected event attributes in %s", record)
+	}
+
+	if event.Data.Labels["pid"] != "42" || event.Data.Labels["errno"] != "111" {
+		t.Errorf("Unexpected labels in %s", record)
+	}
+
+	if _, err := e.decodeEventLabels(stream, raw[:4]); err == nil {
+		t.Errorf("Expected error decoding short record")
+	}
+}
+
+func TestEventsHandler(t *testing.T) {
+	e := &Exporter{
+		eventSubscribers: newEventSubscribers(),
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(e.EventsHandler))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "?program=tcp")
+	if err != nil {
+		t.Fatalf("Error requesting events: %s", err)
+	}
+	defer resp.Body.Close()
+
+	e.eventSubscribers.publish("bio", "slow", "{\"skipped\":true}\n")
+	e.eventSubscribers.publish("tcp", "connect_errors", "{\"matched\":true}\n")
+
+	reader := bufio.NewReader(resp.Body)
+
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatalf("Error reading event: %s", err)
+	}
+
+	if line != "{\"matched\":true}\n" {
+		t.Errorf("Expected only matching records, got %q", line)
+	}
+
+	e.StopEventsHandler()
+
+	if _, err := reader.ReadString('\n'); err == nil {
+		t.Errorf("Expected stream to end after stopping events handler")
+	}
+
+	if _, err := e.eventSubscribers.subscribe("", ""); err == nil {
+		t.Errorf("Expected new subscribers to be rejected after stopping")
+	}
+}
+
+func TestReadEventsDrainsOnStop(t *testing.T) {
+	e := &Exporter{
+		decoders: decoder.NewSet(),
+		events:   newCloudEventer(config.CloudEvents{Source: "/test"}, nil),
+		sinkChan: make(chan []string, 10),
+	}
+
+	stream := &eventStream{
+		program: "tcp",
+		size:    4,
+		config: config.Event{
+			Name:   "connect_errors",
+			Sink:   true,
+			Labels: []config.Label{{Name: "pid", Size: 4, Decoders: []config.Decoder{{Name: "uint"}}}},
+		},
+		records: make(chan []byte, 10),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+
+	for pid := uint32(1); pid <= 3; pid++ {
+		raw := make([]byte, 4)
+		bcc.GetHostByteOrder().PutUint32(raw, pid)
+		stream.records <- raw
+	}
+
+	// Stopped before reading anything, buffered records must still be sinked
+	close(stream.done)
+
+	e.readEvents(stream)
+
+	if received := stream.received; received != 3 {
+		t.Errorf("Expected 3 records received, got %d", received)
+	}
+
+	select {
+	case batch := <-e.sinkChan:
+		if len(batch) != 3 {
+			t.Errorf("Expected 3 records in sink batch, got %d", len(batch))
+		}
+	default:
+		t.Errorf("Expected buffered records to be sinked on stop")
+	}
+}
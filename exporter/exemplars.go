@@ -0,0 +1,144 @@
+package exporter
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/ahas-sigs/kube-ebpf-exporter/config"
+	"github.com/ahas-sigs/kube-ebpf-exporter/decoder"
+	"github.com/iovisor/gobpf/bcc"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// exemplarHistogram is a histogram with exemplars on buckets, which are
+// only exposed when the scraper negotiates OpenMetrics format
+type exemplarHistogram struct {
+	prometheus.Metric
+	// exemplars maps upper limits of buckets to labels of exemplars
+	exemplars map[float64]prometheus.Labels
+}
+
+// newExemplarHistogram attaches exemplars to buckets of the histogram
+func newExemplarHistogram(metric prometheus.Metric, exemplars map[float64]prometheus.Labels) prometheus.Metric {
+	if len(exemplars) == 0 {
+		return metric
+	}
+
+	return &exemplarHistogram{Metric: metric, exemplars: exemplars}
+}
+
+// Write adds exemplars to buckets written by the histogram. Tables only
+// know the bucket and not the value, so the value of the exemplar is the
+// upper limit of the bucket.
+func (h *exemplarHistogram) Write(m *dto.Metric) error {
+	if err := h.Metric.Write(m); err != nil {
+		return err
+	}
+
+	for _, bucket := range m.GetHistogram().GetBucket() {
+		labels, ok := h.exemplars[bucket.GetUpperBound()]
+		if !ok {
+			continue
+		}
+
+		value := bucket.GetUpperBound()
+		bucket.Exemplar = &dto.Exemplar{Label: exemplarLabelPairs(labels), Value: &value}
+	}
+
+	return nil
+}
+
+// exemplarLabelPairs returns label pairs sorted by name
+func exemplarLabelPairs(labels prometheus.Labels) []*dto.LabelPair {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	pairs := make([]*dto.LabelPair, 0, len(names))
+	for _, name := range names {
+		name, value := name, labels[name]
+		pairs = append(pairs, &dto.LabelPair{Name: &name, Value: &value})
+	}
+
+	return pairs
+}
+
+// exemplarLabels returns labels of the exemplar or false if they
+// do not fit into the limit of OpenMetrics on exemplar size
+func exemplarLabels(labels []config.Label, values []string) (prometheus.Labels, bool) {
+	exemplar := make(prometheus.Labels, len(labels))
+
+	runes := 0
+	for i, label := range labels {
+		exemplar[label.Name] = values[i]
+		runes += utf8.RuneCountInString(label.Name) + utf8.RuneCountInString(values[i])
+	}
+
+	return exemplar, runes <= prometheus.ExemplarMaxRunes
+}
+
+// tableExemplars reads the exemplar table of the histogram and groups
+// exemplars by labels of the histogram and upper limits of buckets
+func (e *Exporter) tableExemplars(module *bcc.Module, histogram config.Histogram) (map[string]map[float64]prometheus.Labels, error) {
+	keyer, err := histogramKeyerMaker(histogram)
+	if err != nil {
+		return nil, err
+	}
+
+	exemplars := map[string]map[float64]prometheus.Labels{}
+
+	table := bcc.NewTable(module.TableId(histogram.Exemplars.Table), module)
+	iter := table.Iter()
+
+	for iter.Next() {
+		labels, err := e.decoders.DecodeLabels(iter.Key(), histogram.Labels)
+		if err != nil {
+			if err == decoder.ErrSkipLabelSet {
+				continue
+			}
+
+			return nil, err
+		}
+
+		// Processes that already exited cannot be decoded
+		values, err := e.decoders.DecodeLabels(iter.Leaf(), histogram.Exemplars.Labels)
+		if err != nil {
+			if err == decoder.ErrSkipLabelSet {
+				continue
+			}
+
+			return nil, err
+		}
+
+		slot, err := strconv.ParseUint(labels[len(labels)-1], 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing slot for exemplar %#v: %s", labels, err)
+		}
+
+		// Underflow, overflow and sum slots have no buckets
+		if int(slot) < histogram.BucketMin || int(slot) > histogram.BucketMax {
+			continue
+		}
+
+		exemplar, ok := exemplarLabels(histogram.Exemplars.Labels, values)
+		if !ok {
+			continue
+		}
+
+		key := fmt.Sprintf("%#v", labels[0:len(labels)-1])
+
+		if _, ok := exemplars[key]; !ok {
+			exemplars[key] = map[float64]prometheus.Labels{}
+		}
+
+		exemplars[key][keyer(float64(slot))] = exemplar
+	}
+
+	return exemplars, nil
+}
@@ -0,0 +1,81 @@
+package exporter
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/ahas-sigs/kube-ebpf-exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+func TestExemplarHistogram(t *testing.T) {
+	desc := prometheus.NewDesc("latency_seconds", "Latency", []string{"device"}, nil)
+
+	exemplars := map[float64]prometheus.Labels{
+		4: {"pid": "123", "app_namespace": "kube-system"},
+		// Buckets that the histogram does not have are ignored
+		16: {"pid": "456"},
+	}
+
+	metric := newExemplarHistogram(prometheus.MustNewConstHistogram(desc, 3, 5, map[float64]uint64{2: 1, 4: 3}, "sda"), exemplars)
+
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(&staticCollector{desc: desc, metric: metric})
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Error gathering metrics: %s", err)
+	}
+
+	buf := &bytes.Buffer{}
+	for _, family := range families {
+		if _, err := expfmt.MetricFamilyToOpenMetrics(buf, family); err != nil {
+			t.Fatalf("Error encoding metrics: %s", err)
+		}
+	}
+
+	expected := []string{
+		`latency_seconds_bucket{device="sda",le="2.0"} 1` + "\n",
+		`latency_seconds_bucket{device="sda",le="4.0"} 3 # {app_namespace="kube-system",pid="123"} 4.0` + "\n",
+		`latency_seconds_bucket{device="sda",le="+Inf"} 3` + "\n",
+	}
+
+	for _, line := range expected {
+		if !strings.Contains(buf.String(), line) {
+			t.Errorf("Expected line %q in output, got:\n%s", line, buf.String())
+		}
+	}
+}
+
+func TestExemplarLabels(t *testing.T) {
+	labels := []config.Label{{Name: "pid"}, {Name: "app_container"}}
+
+	exemplar, ok := exemplarLabels(labels, []string{"123", "coredns"})
+	if !ok {
+		t.Fatalf("Expected exemplar to fit")
+	}
+
+	if exemplar["pid"] != "123" || exemplar["app_container"] != "coredns" {
+		t.Errorf("Expected pid=123 and app_container=coredns, got %v", exemplar)
+	}
+
+	if _, ok := exemplarLabels(labels, []string{"123", strings.Repeat("x", 64)}); ok {
+		t.Errorf("Expected exemplar with long container name not to fit")
+	}
+}
+
+// staticCollector collects a single metric
+type staticCollector struct {
+	desc   *prometheus.Desc
+	metric prometheus.Metric
+}
+
+func (c *staticCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *staticCollector) Collect(ch chan<- prometheus.Metric) {
+	ch <- c.metric
+}
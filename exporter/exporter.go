@@ -1,16 +1,14 @@
 package exporter
 
 import (
-	"bufio"
-	"compress/gzip"
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
-	"net/http"
 	"os"
 	"strconv"
-	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ahas-sigs/kube-ebpf-exporter/config"
@@ -27,33 +25,9 @@ var (
 )
 
 const (
-	// Namespace to use for all metrics
-	prometheusNamespace = "ebpf_exporter"
-	ahasSinkRootPath    = "/ahas-workspace/data/ahas/ahas-agent/ebpf-exporter/data"
+	// ahasSinkRootPath is the default sink root when sink.root is not set
+	ahasSinkRootPath = "/ahas-workspace/data/ahas/ahas-agent/ebpf-exporter/data"
 )
-const (
-	ahasEventNodeKey           = "ahas_event_node"
-	ahasEventClusterKey        = "ahas_event_cluster"
-	ahasEventZoneKey           = "ahas_event_zone"
-	ahasEventRegionKey         = "ahas_event_region"
-	ahasEventProviderKey       = "ahas_event_provider"
-	ahasEventTimeKey           = "ahas_event_time"
-	ahasEventNameKey           = "ahas_event_name"
-	ahasEventValueKey          = "ahas_event_value"
-	ahasEventEbpfExporterStart = "ahas-sigs.cloudevents.kube-ebpf-exporter.start"
-	ahasEventPath              = "/ahas-workspace/data/ahas/ahas-agent/ebpf-exporter/event.dat"
-)
-const (
-	ahasSinkNodeKey     = "ahas_sink_node"
-	ahasSinkClusterKey  = "ahas_sink_cluster"
-	ahasSinkZoneKey     = "ahas_sink_zone"
-	ahasSinkRegionKey   = "ahas_sink_region"
-	ahasSinkProviderKey = "ahas_sink_provider"
-	ahasSinkTimeKey     = "ahas_sink_time"
-	ahasSinkNameKey     = "ahas_sink_name"
-	ahasSinkValueKey    = "ahas_sink_value"
-)
-
 const (
 	///only enable export, disable sink, default mode
 	Sink_Mode_None = 0
@@ -65,42 +39,128 @@ const (
 
 // Exporter is a ebpf_exporter instance implementing prometheus.Collector
 type Exporter struct {
-	nodeID              string
-	nodeZone            string
-	nodeRegion          string
-	nodeProvider        string
-	nodeCluster         string
-	sinkRoot            string
-	sinkOutPutFile      string
-	config              config.Config
-	modules             map[string]*bcc.Module
-	ksyms               map[uint64]string
-	enabledProgramsDesc *prometheus.Desc
-	programInfoDesc     *prometheus.Desc
-	programTags         map[string]map[string]uint64
-	descs               map[string]map[string]*prometheus.Desc
-	decoders            *decoder.Set
-	sinkChan            chan []string
-	sinkMutex           sync.Mutex
+	nodeID                 string
+	nodeZone               string
+	nodeRegion             string
+	nodeProvider           string
+	nodeCluster            string
+	config                 config.Config
+	modules                map[string]*bcc.Module
+	ksyms                  map[uint64]string
+	enabledProgramsDesc    *prometheus.Desc
+	programInfoDesc        *prometheus.Desc
+	sinkBytesDesc          *prometheus.Desc
+	sinkRemovedDesc        *prometheus.Desc
+	sinkQueueDesc          *prometheus.Desc
+	sinkDroppedDesc        *prometheus.Desc
+	cloudEventsDroppedDesc *prometheus.Desc
+	eventsDesc             *prometheus.Desc
+	eventsDroppedDesc      *prometheus.Desc
+	eventSeriesDesc        *prometheus.Desc
+	programTags            map[string]map[string]uint64
+	descs                  map[string]map[string]*prometheus.Desc
+	decoders               *decoder.Set
+	sinkChan               chan []string
+	sinkLock               sync.RWMutex
+	sinkClosed             bool
+	sinkDone               chan struct{}
+	sinkDropped            uint64
+	sink                   *sinkWriter
+	events                 *cloudEventer
+	sinkDeltas             *deltaTracker
+	eventStreams           map[string][]*eventStream
+	eventSubscribers       *eventSubscribers
+	eventCounters          map[string]map[string]*eventCounter
+	eventHistograms        map[string]map[string]*eventHistogram
+	programStatus          map[string]*programStatus
+	perfEventFds           map[string][]int
 }
 
 // New creates a new exporter with the provided config
-func New(nodeID string, config config.Config) *Exporter {
+func New(nodeID string, conf config.Config) (*Exporter, error) {
+	// Metrics about the exporter itself get the global namespace
+	prometheusNamespace := config.MetricNamespace(conf.Global, config.Program{})
+
+	// External labels are added to metrics about the exporter as well
+	externalLabels := prometheus.Labels(conf.Global.ExternalLabels)
 
 	enabledProgramsDesc := prometheus.NewDesc(
 		prometheus.BuildFQName(prometheusNamespace, "", "enabled_programs"),
 		"The set of enabled programs",
 		[]string{"name"},
-		nil,
+		externalLabels,
 	)
 
 	programInfoDesc := prometheus.NewDesc(
 		prometheus.BuildFQName(prometheusNamespace, "", "ebpf_programs"),
 		"Info about ebpf programs",
 		[]string{"program", "function", "tag"},
+		externalLabels,
+	)
+
+	sinkBytesDesc := prometheus.NewDesc(
+		prometheus.BuildFQName(prometheusNamespace, "sink", "written_bytes_total"),
+		"Total number of compressed bytes written to sink files",
+		nil,
+		externalLabels,
+	)
+
+	sinkRemovedDesc := prometheus.NewDesc(
+		prometheus.BuildFQName(prometheusNamespace, "sink", "removed_files_total"),
+		"Total number of sink files removed by retention",
+		nil,
+		externalLabels,
+	)
+
+	sinkQueueDesc := prometheus.NewDesc(
+		prometheus.BuildFQName(prometheusNamespace, "sink", "queue_length"),
+		"Number of record batches waiting to be written to sink files",
+		nil,
+		externalLabels,
+	)
+
+	sinkDroppedDesc := prometheus.NewDesc(
+		prometheus.BuildFQName(prometheusNamespace, "sink", "records_dropped_total"),
+		"Total number of sink records dropped because the sink queue was full",
+		nil,
+		externalLabels,
+	)
+
+	cloudEventsDroppedDesc := prometheus.NewDesc(
+		prometheus.BuildFQName(prometheusNamespace, "", "cloudevents_dropped_total"),
+		"Total number of lifecycle cloud events not delivered to the http endpoint because the queue was full or closed",
+		nil,
+		externalLabels,
+	)
+
+	eventsDesc := prometheus.NewDesc(
+		prometheus.BuildFQName(prometheusNamespace, "", "events_total"),
+		"Total number of decoded records from perf output tables",
+		[]string{"program", "event"},
+		externalLabels,
+	)
+
+	eventsDroppedDesc := prometheus.NewDesc(
+		prometheus.BuildFQName(prometheusNamespace, "", "events_stream_dropped_total"),
+		"Total number of event records dropped for slow /events clients",
 		nil,
+		externalLabels,
 	)
 
+	eventSeriesDesc := prometheus.NewDesc(
+		prometheus.BuildFQName(prometheusNamespace, "", "event_metric_series_dropped_total"),
+		"Total number of event records not counted because the metric reached max_series",
+		[]string{"program", "metric"},
+		externalLabels,
+	)
+
+	sink, err := sinkQueueDefaults(conf.Sink)
+	if err != nil {
+		return nil, err
+	}
+
+	conf.Sink = sink
+
 	nodeProvider := os.Getenv("AHAS_NODE_PROVIDER")
 	if len(nodeProvider) > 1 {
 		ahasSinkNodeProvider = nodeProvider
@@ -118,84 +178,352 @@ func New(nodeID string, config config.Config) *Exporter {
 		ahasSinkNodeCluster = nodeCluster
 	}
 
+	sinkRootPath := conf.Sink.Root
+	if sinkRootPath == "" {
+		sinkRootPath = ahasSinkRootPath
+	}
+
 	sinkRoot := fmt.Sprintf("%s/%s/node/%s",
-		ahasSinkRootPath,
+		sinkRootPath,
 		ahasSinkNodeCluster,
 		nodeID)
 	_ = os.MkdirAll(sinkRoot, 0777)
 
+	if conf.CloudEvents.Source == "" {
+		conf.CloudEvents.Source = fmt.Sprintf("/ahas-sigs/kube-ebpf-exporter/%s/node/%s", ahasSinkNodeCluster, nodeID)
+	}
+
+	extensions := map[string]string{
+		"node":     nodeID,
+		"zone":     ahasSinkNodeZone,
+		"region":   ahasSinkNodeRegion,
+		"provider": ahasSinkNodeProvider,
+		"cluster":  ahasSinkNodeCluster,
+	}
+
+	addLabelExtensions(extensions, conf.Global.ExternalLabels)
+
+	events := newCloudEventer(conf.CloudEvents, extensions)
+
 	e := &Exporter{
-		nodeID:              nodeID,
-		nodeZone:            ahasSinkNodeZone,
-		nodeCluster:         ahasSinkNodeCluster,
-		nodeRegion:          ahasSinkNodeRegion,
-		nodeProvider:        ahasSinkNodeProvider,
-		sinkRoot:            sinkRoot,
-		sinkOutPutFile:      sinkRoot,
-		config:              config,
-		modules:             map[string]*bcc.Module{},
-		ksyms:               map[uint64]string{},
-		enabledProgramsDesc: enabledProgramsDesc,
-		programInfoDesc:     programInfoDesc,
-		programTags:         map[string]map[string]uint64{},
-		descs:               map[string]map[string]*prometheus.Desc{},
-		decoders:            decoder.NewSet(),
-		sinkChan:            make(chan []string, 5000),
+		nodeID:                 nodeID,
+		nodeZone:               ahasSinkNodeZone,
+		nodeCluster:            ahasSinkNodeCluster,
+		nodeRegion:             ahasSinkNodeRegion,
+		nodeProvider:           ahasSinkNodeProvider,
+		config:                 conf,
+		modules:                map[string]*bcc.Module{},
+		ksyms:                  map[uint64]string{},
+		enabledProgramsDesc:    enabledProgramsDesc,
+		programInfoDesc:        programInfoDesc,
+		sinkBytesDesc:          sinkBytesDesc,
+		sinkRemovedDesc:        sinkRemovedDesc,
+		sinkQueueDesc:          sinkQueueDesc,
+		sinkDroppedDesc:        sinkDroppedDesc,
+		cloudEventsDroppedDesc: cloudEventsDroppedDesc,
+		eventsDesc:             eventsDesc,
+		eventsDroppedDesc:      eventsDroppedDesc,
+		eventSeriesDesc:        eventSeriesDesc,
+		programTags:            map[string]map[string]uint64{},
+		descs:                  map[string]map[string]*prometheus.Desc{},
+		decoders:               decoder.NewSet(),
+		sinkChan:               make(chan []string, conf.Sink.QueueSize),
+		sinkDone:               make(chan struct{}),
+		sink:                   newSinkWriter(sinkRoot, conf.Sink),
+		events:                 events,
+		sinkDeltas:             newDeltaTracker(),
+		eventStreams:           map[string][]*eventStream{},
+		eventSubscribers:       newEventSubscribers(),
+		eventCounters:          map[string]map[string]*eventCounter{},
+		eventHistograms:        map[string]map[string]*eventHistogram{},
+		programStatus:          map[string]*programStatus{},
+		perfEventFds:           map[string][]int{},
 	}
+
+	programs := []string{}
+	for _, program := range conf.Programs {
+		programs = append(programs, program.Name)
+	}
+
+	e.events.emit(cloudEventTypeStart, "", map[string]interface{}{
+		"sink_root": sinkRoot,
+		"programs":  programs,
+	})
+
 	go e.dumpSinkValues()
-	return e
+	return e, nil
 }
 
-// Attach injects eBPF into kernel and attaches necessary kprobes
-func (e *Exporter) Attach() error {
+// Attach injects eBPF into kernel and attaches necessary kprobes. The error
+// of a program that fails to attach is recorded in its status. By default
+// the first failure is returned, with skipFailed failed programs are skipped
+// and an error is only returned if none of the programs could be attached.
+func (e *Exporter) Attach(skipFailed bool) error {
+	attached := 0
+
+	var lastErr error
+
 	for _, program := range e.config.Programs {
-		if _, ok := e.modules[program.Name]; ok {
+		if _, ok := e.programStatus[program.Name]; ok {
 			return fmt.Errorf("multiple programs with name %q", program.Name)
 		}
 
-		module := bcc.NewModule(program.Code, program.Cflags)
-		if module == nil {
-			return fmt.Errorf("error compiling module for program %q", program.Name)
+		status := &programStatus{}
+		e.programStatus[program.Name] = status
+
+		start := time.Now()
+
+		if err := e.attachProgram(program); err != nil {
+			status.err = err.Error()
+
+			if !skipFailed {
+				return err
+			}
+
+			log.Printf("Error attaching program %q, skipping it: %s", program.Name, err)
+			lastErr = err
+			continue
 		}
 
-		tags, err := attach(module, program.Kprobes, program.Kretprobes, program.Tracepoints, program.RawTracepoints)
+		attached++
 
+		status.attached = true
+		status.loadTime = start
+		status.loadDuration = time.Since(start)
+	}
+
+	if attached == 0 && lastErr != nil {
+		return fmt.Errorf("none of %d programs could be attached, last error: %s", len(e.config.Programs), lastErr)
+	}
+
+	return nil
+}
+
+// attachProgram compiles the program and attaches it to the kernel,
+// nothing is left compiled or attached if any of the steps fails
+func (e *Exporter) attachProgram(program config.Program) (err error) {
+	module := bcc.NewModule(program.Code, program.Cflags)
+	if module == nil {
+		return fmt.Errorf("error compiling module for program %q", program.Name)
+	}
+
+	e.modules[program.Name] = module
+
+	defer func() {
+		if err != nil {
+			e.releaseProgram(program.Name)
+		}
+	}()
+
+	tags, err := attach(module, program.Kprobes, program.Kretprobes, program.Tracepoints, program.RawTracepoints)
+
+	if err != nil {
+		return fmt.Errorf("failed to attach to program %q: %s", program.Name, err)
+	}
+
+	e.programTags[program.Name] = tags
+
+	for _, perfEventConfig := range program.PerfEvents {
+		err = e.attachPerfEvent(program.Name, module, perfEventConfig)
 		if err != nil {
-			return fmt.Errorf("failed to attach to program %q: %s", program.Name, err)
+			return fmt.Errorf("failed to attach perf event %s:%s to %q in program %q: %s", perfEventConfig.Type, perfEventConfig.Name, perfEventConfig.Target, program.Name, err)
 		}
+	}
 
-		e.programTags[program.Name] = tags
+	if err := e.createEventMetrics(program); err != nil {
+		return fmt.Errorf("failed to create event metrics in program %q: %s", program.Name, err)
+	}
 
-		for _, perfEventConfig := range program.PerfEvents {
-			target, err := module.LoadPerfEvent(perfEventConfig.Target)
-			if err != nil {
-				return fmt.Errorf("failed to load target %q in program %q: %s", perfEventConfig.Target, program.Name, err)
+	for _, event := range program.Events {
+		counters := []*eventCounter{}
+		for _, counter := range program.Metrics.Counters {
+			if counter.Event == event.Name {
+				counters = append(counters, e.eventCounters[program.Name][counter.Name])
 			}
+		}
 
-			err = module.AttachPerfEvent(perfEventConfig.Type, perfEventConfig.Name, perfEventConfig.SamplePeriod, perfEventConfig.SampleFrequency, -1, -1, -1, target)
-			if err != nil {
-				return fmt.Errorf("failed to attach perf event %d:%d to %q in program %q: %s", perfEventConfig.Type, perfEventConfig.Name, perfEventConfig.Target, program.Name, err)
+		histograms := []*eventHistogram{}
+		for _, histogram := range program.Metrics.Histograms {
+			if histogram.Event == event.Name {
+				histograms = append(histograms, e.eventHistograms[program.Name][histogram.Name])
 			}
 		}
 
-		e.modules[program.Name] = module
+		stream, err := e.startEventStream(program.Name, module, event, counters, histograms)
+		if err != nil {
+			return fmt.Errorf("failed to start event stream in program %q: %s", program.Name, err)
+		}
+
+		e.eventStreams[program.Name] = append(e.eventStreams[program.Name], stream)
+	}
+
+	functionTags := map[string]string{}
+	for function, tag := range tags {
+		functionTags[function] = fmt.Sprintf("%x", tag)
 	}
+
+	e.events.emit(cloudEventTypeAttach, program.Name, map[string]interface{}{
+		"program": program.Name,
+		"tags":    functionTags,
+	})
+
 	return nil
 }
 
+// createEventMetrics creates aggregators for metrics of the program
+// that are counted from event records rather than read from tables
+func (e *Exporter) createEventMetrics(program config.Program) error {
+	events := map[string]config.Event{}
+	for _, event := range program.Events {
+		events[event.Name] = event
+	}
+
+	counters := map[string]*eventCounter{}
+	for _, counter := range program.Metrics.Counters {
+		if counter.Event == "" {
+			continue
+		}
+
+		event, ok := events[counter.Event]
+		if !ok {
+			return fmt.Errorf("counter %q refers to unknown event %q", counter.Name, counter.Event)
+		}
+
+		aggregator, err := newEventCounter(program.Name, counter, event)
+		if err != nil {
+			return err
+		}
+
+		counters[counter.Name] = aggregator
+	}
+
+	histograms := map[string]*eventHistogram{}
+	for _, histogram := range program.Metrics.Histograms {
+		if histogram.Event == "" {
+			continue
+		}
+
+		event, ok := events[histogram.Event]
+		if !ok {
+			return fmt.Errorf("histogram %q refers to unknown event %q", histogram.Name, histogram.Event)
+		}
+
+		aggregator, err := newEventHistogram(program.Name, histogram, event)
+		if err != nil {
+			return err
+		}
+
+		histograms[histogram.Name] = aggregator
+	}
+
+	e.eventCounters[program.Name] = counters
+	e.eventHistograms[program.Name] = histograms
+
+	return nil
+}
+
+// Close stops accepting sink values, waits for the queued ones to be written
+// and flushed to disk until the context is done and then detaches programs
+func (e *Exporter) Close(ctx context.Context) error {
+	// Event streams flush their pending records into the sink when stopped
+	for _, program := range e.config.Programs {
+		e.stopEventStreams(program.Name)
+	}
+
+	e.sinkLock.Lock()
+	if !e.sinkClosed {
+		e.sinkClosed = true
+		close(e.sinkChan)
+	}
+	e.sinkLock.Unlock()
+
+	var err error
+
+	select {
+	case <-e.sinkDone:
+	case <-ctx.Done():
+		err = fmt.Errorf("error waiting for sink to drain %d queued batches: %s", len(e.sinkChan), ctx.Err())
+	}
+
+	e.Detach()
+
+	// Detach events are queued for delivery by Detach
+	if cerr := e.events.close(ctx); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// Detach removes eBPF programs from the kernel
+func (e *Exporter) Detach() {
+	for _, program := range e.config.Programs {
+		if _, ok := e.modules[program.Name]; !ok {
+			continue
+		}
+
+		e.releaseProgram(program.Name)
+
+		if status, ok := e.programStatus[program.Name]; ok {
+			status.attached = false
+		}
+
+		e.events.emit(cloudEventTypeDetach, program.Name, map[string]interface{}{
+			"program": program.Name,
+		})
+	}
+}
+
+// releaseProgram stops everything started for the program
+// and closes its module, which removes it from the kernel
+func (e *Exporter) releaseProgram(programName string) {
+	e.stopEventStreams(programName)
+	e.closePerfEvents(programName)
+
+	if module, ok := e.modules[programName]; ok {
+		module.Close()
+	}
+
+	delete(e.modules, programName)
+	delete(e.programTags, programName)
+	delete(e.eventCounters, programName)
+	delete(e.eventHistograms, programName)
+}
+
+// stopEventStreams stops all event streams of the program
+func (e *Exporter) stopEventStreams(programName string) {
+	for _, stream := range e.eventStreams[programName] {
+		stream.stop()
+	}
+
+	delete(e.eventStreams, programName)
+}
+
 // Describe satisfies prometheus.Collector interface by sending descriptions
 // for all metrics the exporter can possibly report
 func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
-	addDescs := func(programName string, name string, help string, labels []config.Label) {
+	addDescs := func(program config.Program, name string, help string, labels []config.Label, noNodeID bool) {
+		programName := program.Name
+
 		if _, ok := e.descs[programName][name]; !ok {
 			labelNames := []string{}
 
-			constLabels := prometheus.Labels{"node_id": e.nodeID}
+			constLabels := prometheus.Labels{}
+			if !noNodeID {
+				constLabels["node_id"] = e.nodeID
+			}
+
+			for labelName, value := range e.config.Global.ExternalLabels {
+				constLabels[labelName] = value
+			}
+
 			for _, label := range labels {
 				labelNames = append(labelNames, label.Name)
 			}
 
-			e.descs[programName][name] = prometheus.NewDesc(prometheus.BuildFQName(prometheusNamespace, "", name), help, labelNames, constLabels)
+			namespace := config.MetricNamespace(e.config.Global, program)
+
+			e.descs[programName][name] = prometheus.NewDesc(prometheus.BuildFQName(namespace, "", name), help, labelNames, constLabels)
 		}
 
 		ch <- e.descs[programName][name]
@@ -203,6 +531,14 @@ func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
 
 	ch <- e.enabledProgramsDesc
 	ch <- e.programInfoDesc
+	ch <- e.sinkBytesDesc
+	ch <- e.sinkRemovedDesc
+	ch <- e.sinkQueueDesc
+	ch <- e.sinkDroppedDesc
+	ch <- e.cloudEventsDroppedDesc
+	ch <- e.eventsDesc
+	ch <- e.eventsDroppedDesc
+	ch <- e.eventSeriesDesc
 
 	for _, program := range e.config.Programs {
 		if _, ok := e.descs[program.Name]; !ok {
@@ -210,11 +546,20 @@ func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
 		}
 
 		for _, counter := range program.Metrics.Counters {
-			addDescs(program.Name, counter.Name, counter.Help, counter.Labels)
+			addDescs(program, counter.Name, counter.Help, counter.Labels, counter.NoNodeID)
 		}
 
 		for _, histogram := range program.Metrics.Histograms {
-			addDescs(program.Name, histogram.Name, histogram.Help, histogram.Labels[0:len(histogram.Labels)-1])
+			labels := histogram.Labels[0 : len(histogram.Labels)-1]
+
+			if !histogram.NoBuckets {
+				addDescs(program, histogram.Name, histogram.Help, labels, histogram.NoNodeID)
+			}
+
+			if len(histogram.Quantiles) > 0 {
+				quantileLabels := append(append([]config.Label{}, labels...), config.Label{Name: "quantile"})
+				addDescs(program, histogram.QuantileName(), histogram.Help, quantileLabels, histogram.NoNodeID)
+			}
 		}
 	}
 }
@@ -231,6 +576,32 @@ func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
 		}
 	}
 
+	ch <- prometheus.MustNewConstMetric(e.sinkBytesDesc, prometheus.CounterValue, float64(atomic.LoadUint64(&e.sink.bytesTotal)))
+	ch <- prometheus.MustNewConstMetric(e.sinkRemovedDesc, prometheus.CounterValue, float64(atomic.LoadUint64(&e.sink.removedTotal)))
+	ch <- prometheus.MustNewConstMetric(e.sinkQueueDesc, prometheus.GaugeValue, float64(len(e.sinkChan)))
+	ch <- prometheus.MustNewConstMetric(e.sinkDroppedDesc, prometheus.CounterValue, float64(atomic.LoadUint64(&e.sinkDropped)))
+	ch <- prometheus.MustNewConstMetric(e.cloudEventsDroppedDesc, prometheus.CounterValue, float64(atomic.LoadUint64(&e.events.dropped)))
+
+	for program, streams := range e.eventStreams {
+		for _, stream := range streams {
+			ch <- prometheus.MustNewConstMetric(e.eventsDesc, prometheus.CounterValue, float64(atomic.LoadUint64(&stream.received)), program, stream.config.Name)
+		}
+	}
+
+	ch <- prometheus.MustNewConstMetric(e.eventsDroppedDesc, prometheus.CounterValue, float64(atomic.LoadUint64(&e.eventSubscribers.dropped)))
+
+	for program, counters := range e.eventCounters {
+		for name, counter := range counters {
+			ch <- prometheus.MustNewConstMetric(e.eventSeriesDesc, prometheus.CounterValue, float64(atomic.LoadUint64(&counter.dropped)), program, name)
+		}
+	}
+
+	for program, histograms := range e.eventHistograms {
+		for name, histogram := range histograms {
+			ch <- prometheus.MustNewConstMetric(e.eventSeriesDesc, prometheus.CounterValue, float64(atomic.LoadUint64(&histogram.dropped)), program, name)
+		}
+	}
+
 	e.collectCounters(ch)
 	e.collectHistograms(ch)
 }
@@ -238,12 +609,33 @@ func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
 // collectCounters sends all known counters to prometheus
 func (e *Exporter) collectCounters(ch chan<- prometheus.Metric) {
 	allSinkValues := []string{}
+	now := time.Now()
 	for _, program := range e.config.Programs {
+		// Programs that failed to attach have no tables to read
+		if _, ok := e.modules[program.Name]; !ok {
+			continue
+		}
+
 		for _, counter := range program.Metrics.Counters {
-			tableValues, sinkValues, err := e.tableValues(e.modules[program.Name], counter.Table, counter.Labels)
-			if err != nil {
-				log.Printf("Error getting table %q values for metric %q of program %q: %s", counter.Table, counter.Name, program.Name, err)
-				continue
+			source := counter.Table
+			var tableValues []metricValue
+
+			if counter.Event != "" {
+				source = counter.Event
+
+				aggregator, ok := e.eventCounters[program.Name][counter.Name]
+				if !ok {
+					continue
+				}
+
+				tableValues = aggregator.snapshot()
+			} else {
+				var err error
+				tableValues, err = e.tableValues(e.modules[program.Name], counter.Table, counter.Labels)
+				if err != nil {
+					log.Printf("Error getting table %q values for metric %q of program %q: %s", counter.Table, counter.Name, program.Name, err)
+					continue
+				}
 			}
 
 			desc := e.descs[program.Name][counter.Name]
@@ -254,99 +646,206 @@ func (e *Exporter) collectCounters(ch chan<- prometheus.Metric) {
 				}
 			}
 			if counter.SinkMode != Sink_Mode_None {
-				allSinkValues = append(allSinkValues, sinkValues...)
+				allSinkValues = append(allSinkValues, e.sinkRecords(program.Name, counter.Name, source, counter.Labels, tableValues, now)...)
+			}
+		}
+	}
+
+	if len(allSinkValues) > 0 {
+		e.enqueueSinkValues(allSinkValues)
+	}
+}
+
+// enqueueSinkValues passes sink values to the sink goroutine without blocking
+// collection for longer than the configured overflow policy allows
+func (e *Exporter) enqueueSinkValues(sinkValues []string) {
+	e.sinkLock.RLock()
+	defer e.sinkLock.RUnlock()
+
+	if e.sinkClosed {
+		return
+	}
+
+	select {
+	case e.sinkChan <- sinkValues:
+		return
+	default:
+	}
+
+	switch e.config.Sink.OverflowPolicy {
+	case config.SinkOverflowBlock:
+		timer := time.NewTimer(e.config.Sink.BlockTimeout)
+		defer timer.Stop()
+
+		select {
+		case e.sinkChan <- sinkValues:
+			return
+		case <-timer.C:
+		}
+	case config.SinkOverflowDropNewest:
+	case config.SinkOverflowDropOldest:
+		// The sink goroutine may free up space concurrently,
+		// so the dropped batch is only accounted if we got one
+		for attempt := 0; attempt < 3; attempt++ {
+			select {
+			case dropped := <-e.sinkChan:
+				e.dropSinkValues(dropped)
+			default:
+			}
+
+			select {
+			case e.sinkChan <- sinkValues:
+				return
+			default:
 			}
 		}
-		e.sinkChan <- allSinkValues
 	}
+
+	e.dropSinkValues(sinkValues)
+}
+
+// dropSinkValues accounts sink values that did not fit into the queue
+func (e *Exporter) dropSinkValues(sinkValues []string) {
+	atomic.AddUint64(&e.sinkDropped, uint64(len(sinkValues)))
 }
 
 // collectHistograms sends all known historams to prometheus
 func (e *Exporter) collectHistograms(ch chan<- prometheus.Metric) {
 	for _, program := range e.config.Programs {
+		// Programs that failed to attach have no tables to read
+		if _, ok := e.modules[program.Name]; !ok {
+			continue
+		}
+
 		for _, histogram := range program.Metrics.Histograms {
-			skip := false
+			var histograms map[string]histogramWithLabels
+			var exemplars map[string]map[float64]prometheus.Labels
 
-			histograms := map[string]histogramWithLabels{}
+			if histogram.Event != "" {
+				aggregator, ok := e.eventHistograms[program.Name][histogram.Name]
+				if !ok {
+					continue
+				}
 
-			tableValues, _, err := e.tableValues(e.modules[program.Name], histogram.Table, histogram.Labels)
-			if err != nil {
-				log.Printf("Error getting table %q values for metric %q of program %q: %s", histogram.Table, histogram.Name, program.Name, err)
-				continue
-			}
+				histograms = aggregator.snapshot()
+			} else {
+				var err error
+				histograms, err = e.tableHistograms(e.modules[program.Name], histogram)
+				if err != nil {
+					log.Printf("Error getting table %q values for metric %q of program %q: %s", histogram.Table, histogram.Name, program.Name, err)
+					continue
+				}
 
-			// Taking the last label and using int as bucket delimiter, for example:
-			//
-			// Before:
-			// * [sda, read, 1ms] -> 10
-			// * [sda, read, 2ms] -> 2
-			// * [sda, read, 4ms] -> 5
-			//
-			// After:
-			// * [sda, read] -> {1ms -> 10, 2ms -> 2, 4ms -> 5}
-			for _, metricValue := range tableValues {
-				labels := metricValue.labels[0 : len(metricValue.labels)-1]
-
-				key := fmt.Sprintf("%#v", labels)
-
-				if _, ok := histograms[key]; !ok {
-					histograms[key] = histogramWithLabels{
-						labels:  labels,
-						buckets: map[float64]uint64{},
+				if histogram.Exemplars.Table != "" {
+					exemplars, err = e.tableExemplars(e.modules[program.Name], histogram)
+					if err != nil {
+						log.Printf("Error getting exemplars from table %q for metric %q of program %q: %s", histogram.Exemplars.Table, histogram.Name, program.Name, err)
 					}
 				}
+			}
 
-				leUint, err := strconv.ParseUint(metricValue.labels[len(metricValue.labels)-1], 0, 64)
-				if err != nil {
-					log.Printf("Error parsing float value for bucket %#v in table %q of program %q: %s", metricValue.labels, histogram.Table, program.Name, err)
-					skip = true
-					break
+			desc := e.descs[program.Name][histogram.Name]
+
+			for key, histogramSet := range histograms {
+				if len(histogram.Quantiles) > 0 {
+					quantiles, err := histogramQuantiles(e.descs[program.Name][histogram.QuantileName()], histogramSet.buckets, histogram, histogramSet.labels...)
+					if err != nil {
+						log.Printf("Error estimating quantiles for metric %q in program %q: %s", histogram.Name, program.Name, err)
+					}
+
+					for _, quantile := range quantiles {
+						ch <- quantile
+					}
 				}
 
-				histograms[key].buckets[float64(leUint)] = uint64(metricValue.value)
-			}
+				if histogram.NoBuckets {
+					continue
+				}
 
-			if skip {
-				continue
-			}
+				if histogram.Native {
+					metric, err := newNativeHistogram(desc, histogramSet.buckets, histogram, histogramSet.labels...)
+					if err != nil {
+						log.Printf("Error creating native histogram for metric %q in program %q: %s", histogram.Name, program.Name, err)
+						continue
+					}
 
-			desc := e.descs[program.Name][histogram.Name]
+					ch <- metric
+					continue
+				}
 
-			for _, histogramSet := range histograms {
 				buckets, count, sum, err := transformHistogram(histogramSet.buckets, histogram)
 				if err != nil {
 					log.Printf("Error transforming histogram for metric %q in program %q: %s", histogram.Name, program.Name, err)
 					continue
 				}
 
-				// Sum is explicitly set to zero. We only take bucket values from
-				// eBPF tables, which means we lose precision and cannot calculate
-				// average values from histograms anyway.
-				// Lack of sum also means we cannot have +Inf bucket, only some finite
-				// value bucket, eBPF programs must cap bucket values to work with this.
-				ch <- prometheus.MustNewConstHistogram(desc, count, sum, buckets, histogramSet.labels...)
+				// Sum comes from the sum slot if eBPF programs maintain it. Values
+				// above the last bucket only go to +Inf bucket with overflow slot,
+				// otherwise eBPF programs must cap bucket values to work with this.
+				ch <- newExemplarHistogram(prometheus.MustNewConstHistogram(desc, count, sum, buckets, histogramSet.labels...), exemplars[key])
+			}
+		}
+	}
+}
+
+// tableHistograms groups values in the histogram table by labels
+func (e *Exporter) tableHistograms(module *bcc.Module, histogram config.Histogram) (map[string]histogramWithLabels, error) {
+	histograms := map[string]histogramWithLabels{}
+
+	tableValues, err := e.tableValues(module, histogram.Table, histogram.Labels)
+	if err != nil {
+		return nil, err
+	}
+
+	// Taking the last label and using int as bucket delimiter, for example:
+	//
+	// Before:
+	// * [sda, read, 1ms] -> 10
+	// * [sda, read, 2ms] -> 2
+	// * [sda, read, 4ms] -> 5
+	//
+	// After:
+	// * [sda, read] -> {1ms -> 10, 2ms -> 2, 4ms -> 5}
+	for _, metricValue := range tableValues {
+		labels := metricValue.labels[0 : len(metricValue.labels)-1]
+
+		key := fmt.Sprintf("%#v", labels)
+
+		if _, ok := histograms[key]; !ok {
+			histograms[key] = histogramWithLabels{
+				labels:  labels,
+				buckets: map[float64]uint64{},
 			}
 		}
+
+		leUint, err := strconv.ParseUint(metricValue.labels[len(metricValue.labels)-1], 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing float value for bucket %#v: %s", metricValue.labels, err)
+		}
+
+		histograms[key].buckets[float64(leUint)] = uint64(metricValue.value)
 	}
+
+	return histograms, nil
 }
 
 // tableValues returns values in the requested table to be used in metircs
-func (e *Exporter) tableValues(module *bcc.Module, tableName string, labels []config.Label) ([]metricValue, []string, error) {
-	sinkValues := []string{}
-	exportValues := []metricValue{}
+func (e *Exporter) tableValues(module *bcc.Module, tableName string, labels []config.Label) ([]metricValue, error) {
+	values := []metricValue{}
 
 	table := bcc.NewTable(module.TableId(tableName), module)
 	iter := table.Iter()
-	t := time.Now()
-	timeNow := t.UnixNano()
+
 	for iter.Next() {
 		key := iter.Key()
 		raw, err := table.KeyBytesToStr(key)
 		if err != nil {
-			return nil, nil, fmt.Errorf("error decoding key %v", key)
+			return nil, fmt.Errorf("error decoding key %v", key)
 		}
 
+		// The key slice is reused by the iterator
 		mv := metricValue{
+			key:    append([]byte{}, key...),
 			raw:    raw,
 			labels: make([]string, len(labels)),
 		}
@@ -357,116 +856,81 @@ func (e *Exporter) tableValues(module *bcc.Module, tableName string, labels []co
 				continue
 			}
 
-			return nil, nil, err
+			return nil, err
 		}
 
 		value := bcc.GetHostByteOrder().Uint64(iter.Leaf())
 		mv.value = float64(value)
 
-		exportValues = append(exportValues, mv)
-
-		sinkInfo := make(map[string]interface{})
-		for idx, label := range labels {
-			sinkInfo[label.Name] = mv.labels[idx]
-		}
-		if value == 0 {
-			continue
-		}
-		sinkInfo[ahasSinkTimeKey] = timeNow
-		sinkInfo[ahasSinkNameKey] = tableName
-		sinkInfo[ahasSinkNodeKey] = e.nodeID
-		sinkInfo[ahasSinkZoneKey] = e.nodeZone
-		sinkInfo[ahasSinkRegionKey] = e.nodeRegion
-		sinkInfo[ahasSinkProviderKey] = e.nodeProvider
-		sinkInfo[ahasSinkClusterKey] = e.nodeCluster
-		sinkInfo[ahasSinkValueKey] = mv.value
-		jsonStr, err := json.Marshal(sinkInfo)
-		if err == nil {
-			sinkValues = append(sinkValues, fmt.Sprintf("%s\n", string(jsonStr)))
-		}
-
+		values = append(values, mv)
 	}
-	return exportValues, sinkValues, nil
+
+	return values, nil
 }
 
-func (e Exporter) exportTables() (map[string]map[string][]metricValue, error) {
-	tables := map[string]map[string][]metricValue{}
+// sinkRecords turns non-zero table values into CloudEvents sink records
+func (e *Exporter) sinkRecords(programName string, metricName string, tableName string, labels []config.Label, values []metricValue, now time.Time) []string {
+	records := []string{}
 
-	for _, program := range e.config.Programs {
-		module := e.modules[program.Name]
-		if module == nil {
-			return nil, fmt.Errorf("module for program %q is not attached", program.Name)
-		}
-
-		if _, ok := tables[program.Name]; !ok {
-			tables[program.Name] = map[string][]metricValue{}
+	labelValues := func(values []string) map[string]string {
+		result := make(map[string]string, len(labels))
+		for idx, label := range labels {
+			result[label.Name] = values[idx]
 		}
+		return result
+	}
 
-		metricTables := map[string][]config.Label{}
-
-		for _, counter := range program.Metrics.Counters {
-			if counter.Table != "" {
-				metricTables[counter.Table] = counter.Labels
-			}
-		}
+	appendRecord := func(data map[string]interface{}) {
+		event := e.events.newEvent(cloudEventTypeSinkRecord, tableName, now, data)
 
-		for _, histogram := range program.Metrics.Histograms {
-			if histogram.Table != "" {
-				metricTables[histogram.Table] = histogram.Labels
-			}
+		jsonStr, err := json.Marshal(event)
+		if err == nil {
+			records = append(records, fmt.Sprintf("%s\n", string(jsonStr)))
 		}
+	}
 
-		for name, labels := range metricTables {
-			metricValues, _, err := e.tableValues(e.modules[program.Name], name, labels)
-			if err != nil {
-				return nil, fmt.Errorf("error getting values for table %q of program %q: %s", name, program.Name, err)
+	if e.config.Sink.ValueMode == config.SinkValueDelta {
+		// Metrics reading the same table with different labels have
+		// snapshots of their own
+		for _, delta := range e.sinkDeltas.deltas(programName+"/"+metricName+"/"+tableName, values, now) {
+			if delta.value == 0 && !delta.reset {
+				continue
 			}
 
-			tables[program.Name][name] = metricValues
+			appendRecord(map[string]interface{}{
+				"program":        programName,
+				"table":          tableName,
+				"labels":         labelValues(delta.labels),
+				"value":          delta.value,
+				"reset":          delta.reset,
+				"interval_start": delta.start.Format(time.RFC3339Nano),
+				"interval_end":   delta.end.Format(time.RFC3339Nano),
+			})
 		}
-	}
 
-	return tables, nil
-}
-
-// TablesHandler is a debug handler to print raw values of kernel maps
-func (e *Exporter) TablesHandler(w http.ResponseWriter, r *http.Request) {
-	tables, err := e.exportTables()
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Header().Add("Content-type", "text/plain")
-		if _, err = fmt.Fprintf(w, "%s\n", err); err != nil {
-			log.Printf("Error returning error to client %q: %s", r.RemoteAddr, err)
-			return
-		}
-		return
+		return records
 	}
 
-	w.Header().Add("Content-type", "text/plain")
-
-	buf := []byte{}
-
-	for program, tables := range tables {
-		buf = append(buf, fmt.Sprintf("## Program: %s\n\n", program)...)
-
-		for name, table := range tables {
-			buf = append(buf, fmt.Sprintf("### Table: %s\n\n", name)...)
-
-			buf = append(buf, ("```\n")...)
-			for _, row := range table {
-				buf = append(buf, fmt.Sprintf("%s (%v) -> %f\n", row.raw, row.labels, row.value)...)
-			}
-			buf = append(buf, ("```\n\n")...)
+	for _, mv := range values {
+		if mv.value == 0 {
+			continue
 		}
-	}
 
-	if _, err = w.Write(buf); err != nil {
-		log.Printf("Error returning table contents to client %q: %s", r.RemoteAddr, err)
+		appendRecord(map[string]interface{}{
+			"program": programName,
+			"table":   tableName,
+			"labels":  labelValues(mv.labels),
+			"value":   mv.value,
+		})
 	}
+
+	return records
 }
 
 // metricValue is a row in a kernel map
 type metricValue struct {
+	// key is the key as bytes provided by kernel
+	key []byte
 	// raw is a raw key value provided by kernel
 	raw string
 	// labels are decoded from the raw key
@@ -475,72 +939,41 @@ type metricValue struct {
 	value float64
 }
 
-func (e *Exporter) addSinkEvent() {
-	eventInfo := make(map[string]interface{})
-	eventInfo[ahasEventNodeKey] = e.nodeID
-	eventInfo[ahasEventZoneKey] = e.nodeZone
-	eventInfo[ahasEventRegionKey] = e.nodeRegion
-	eventInfo[ahasEventProviderKey] = e.nodeProvider
-	eventInfo[ahasEventClusterKey] = e.nodeCluster
-	eventInfo[ahasEventTimeKey] = time.Now().UnixNano()
-	eventInfo[ahasEventNameKey] = ahasEventEbpfExporterStart
-	eventInfo[ahasEventValueKey] = e.sinkOutPutFile
-
-	fl, err := os.OpenFile(ahasEventPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0777)
-	if err != nil {
-		log.Printf("open %s fail, %s", ahasEventPath, err)
-		return
-	}
-	data, err := json.Marshal(eventInfo)
-	if err != nil {
-		log.Printf("write %s fail, %s", ahasEventPath, err)
-		return
-	}
-	rawEvent := fmt.Sprintf("%s\n", data)
-	_, err = fl.Write([]byte(rawEvent))
-	if err != nil {
-		log.Printf("write %s fail, %s", ahasEventPath, err)
-		return
-	}
-}
-
 func (e *Exporter) dumpSinkValues() {
-	for sinkValues := range e.sinkChan {
-		if len(sinkValues) < 1 {
-			continue
+	ticker := time.NewTicker(e.sink.config.FlushInterval)
+	defer ticker.Stop()
+	defer close(e.sinkDone)
+
+	for {
+		select {
+		case sinkValues, ok := <-e.sinkChan:
+			if !ok {
+				if err := e.sink.close(); err != nil {
+					log.Printf("close sink fail, %s", err)
+				}
+				return
+			}
+			if len(sinkValues) < 1 {
+				continue
+			}
+			e.saveSinkValues(sinkValues)
+		case now := <-ticker.C:
+			if err := e.sink.flush(now); err != nil {
+				log.Printf("%s", err)
+			}
 		}
-		e.saveSinkValues(sinkValues)
 	}
 }
+
 func (e *Exporter) saveSinkValues(sinkValues []string) {
 	log.Printf("recv %d events", len(sinkValues))
-	e.sinkMutex.Lock()
-	defer e.sinkMutex.Unlock()
-	timeNow := time.Now()
-	sinkOutPutFile := fmt.Sprintf("%s/%s.gz",
-		e.sinkRoot,
-		timeNow.Local().Format("2006010215"))
-	fl, err := os.OpenFile(sinkOutPutFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0777)
+	rotated, err := e.sink.write(sinkValues, time.Now())
 	if err != nil {
-		log.Printf("open %s fail, %s", e.sinkOutPutFile, err)
-		return
-	}
-	gf, err := gzip.NewWriterLevel(fl, gzip.BestCompression)
-	if err != nil {
-		log.Printf("new gzip writer %s fail, %s", e.sinkOutPutFile, err)
-		fl.Close()
-		return
-	}
-	fw := bufio.NewWriter(gf)
-	for _, data := range sinkValues {
-		fw.WriteString(data)
+		log.Printf("%s", err)
 	}
-	fw.Flush()
-	gf.Close()
-	fl.Close()
-	if strings.Compare(sinkOutPutFile, e.sinkOutPutFile) != 0 {
-		e.sinkOutPutFile = sinkOutPutFile
-		e.addSinkEvent()
+	if rotated {
+		e.events.emit(cloudEventTypeSinkRotate, e.sink.path, map[string]interface{}{
+			"file": e.sink.path,
+		})
 	}
-	return
 }
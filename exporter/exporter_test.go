@@ -0,0 +1,200 @@
+package exporter
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ahas-sigs/kube-ebpf-exporter/config"
+	"github.com/iovisor/gobpf/bcc"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestDescribeNamespaces(t *testing.T) {
+	e := &Exporter{
+		nodeID: "node-1",
+		config: config.Config{
+			Global: config.Global{Namespace: "node"},
+			Programs: []config.Program{
+				{
+					Name:      "bio",
+					Namespace: "storage",
+					Metrics: config.Metrics{
+						Counters: []config.Counter{{Name: "bio_total", Labels: []config.Label{{Name: "device"}}, NoNodeID: true}},
+					},
+				},
+				{
+					Name: "timers",
+					Metrics: config.Metrics{
+						Histograms: []config.Histogram{{Name: "timer_latency_seconds", Labels: []config.Label{{Name: "bucket"}}}},
+					},
+				},
+			},
+		},
+		descs: map[string]map[string]*prometheus.Desc{},
+	}
+
+	ch := make(chan *prometheus.Desc, 100)
+	e.Describe(ch)
+	close(ch)
+
+	descs := []string{}
+	for desc := range ch {
+		if desc != nil {
+			descs = append(descs, desc.String())
+		}
+	}
+
+	expected := []string{
+		`fqName: "storage_bio_total", help: "", constLabels: {}, variableLabels: [device]`,
+		`fqName: "node_timer_latency_seconds", help: "", constLabels: {node_id="node-1"}, variableLabels: []`,
+	}
+
+	if len(descs) != len(expected) {
+		t.Fatalf("Expected descs %v, got %v", expected, descs)
+	}
+
+	for i, desc := range descs {
+		if !strings.Contains(desc, expected[i]) {
+			t.Errorf("Expected desc %s, got %s", expected[i], desc)
+		}
+	}
+}
+
+func TestDescribeExternalLabels(t *testing.T) {
+	root, err := ioutil.TempDir("", "sink")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(root)
+
+	e, err := New("node-1", config.Config{
+		Global:      config.Global{ExternalLabels: map[string]string{"cluster": "prod"}},
+		Sink:        config.Sink{Root: root},
+		CloudEvents: config.CloudEvents{File: filepath.Join(root, "event.dat")},
+	})
+	if err != nil {
+		t.Fatalf("Error creating exporter: %s", err)
+	}
+
+	ch := make(chan *prometheus.Desc, 100)
+	e.Describe(ch)
+	close(ch)
+
+	count := 0
+	for desc := range ch {
+		count++
+
+		// Metrics about the exporter itself get external labels as well
+		if !strings.Contains(desc.String(), `constLabels: {cluster="prod"}`) {
+			t.Errorf("Expected desc with external labels, got %s", desc)
+		}
+	}
+
+	if count == 0 {
+		t.Errorf("Expected descs about the exporter, got none")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := e.Close(ctx); err != nil {
+		t.Fatalf("Error closing exporter: %s", err)
+	}
+}
+
+func TestDescribeQuantiles(t *testing.T) {
+	e := &Exporter{
+		nodeID: "node-1",
+		config: config.Config{
+			Programs: []config.Program{
+				{
+					Name: "bio",
+					Metrics: config.Metrics{
+						Histograms: []config.Histogram{
+							{Name: "bio_latency_seconds", Labels: []config.Label{{Name: "device"}, {Name: "bucket"}}, Quantiles: []float64{0.5}},
+							{Name: "bio_size_bytes", Labels: []config.Label{{Name: "bucket"}}, Quantiles: []float64{0.5}, NoBuckets: true},
+						},
+					},
+				},
+			},
+		},
+		descs: map[string]map[string]*prometheus.Desc{},
+	}
+
+	ch := make(chan *prometheus.Desc, 100)
+	e.Describe(ch)
+	close(ch)
+
+	descs := []string{}
+	for desc := range ch {
+		if desc != nil && strings.Contains(desc.String(), "bio_") {
+			descs = append(descs, desc.String())
+		}
+	}
+
+	expected := []string{
+		`fqName: "ebpf_exporter_bio_latency_seconds", help: "", constLabels: {node_id="node-1"}, variableLabels: [device]`,
+		`fqName: "ebpf_exporter_bio_latency_seconds_quantile", help: "", constLabels: {node_id="node-1"}, variableLabels: [device quantile]`,
+		`fqName: "ebpf_exporter_bio_size_bytes_quantile", help: "", constLabels: {node_id="node-1"}, variableLabels: [quantile]`,
+	}
+
+	if len(descs) != len(expected) {
+		t.Fatalf("Expected descs %v, got %v", expected, descs)
+	}
+
+	for i, desc := range descs {
+		if !strings.Contains(desc, expected[i]) {
+			t.Errorf("Expected desc %s, got %s", expected[i], desc)
+		}
+	}
+}
+
+func TestAttachFailures(t *testing.T) {
+	programs := []config.Program{
+		{Name: "broken", Code: "this is not c"},
+		{Name: "also_broken", Code: "neither is this"},
+	}
+
+	newExporter := func() *Exporter {
+		return &Exporter{
+			config:        config.Config{Programs: programs},
+			modules:       map[string]*bcc.Module{},
+			programStatus: map[string]*programStatus{},
+		}
+	}
+
+	e := newExporter()
+
+	if err := e.Attach(false); err == nil || !strings.Contains(err.Error(), `"broken"`) {
+		t.Errorf("Expected error attaching the first broken program, got %v", err)
+	}
+
+	if status, ok := e.programStatus["broken"]; !ok || status.err == "" || status.attached {
+		t.Errorf("Expected error recorded for the first broken program, got %#v", status)
+	}
+
+	if _, ok := e.programStatus["also_broken"]; ok {
+		t.Errorf("Expected attaching to stop at the first broken program")
+	}
+
+	e = newExporter()
+
+	if err := e.Attach(true); err == nil || !strings.Contains(err.Error(), "none of 2 programs") {
+		t.Errorf("Expected error with no programs attached, got %v", err)
+	}
+
+	for _, name := range []string{"broken", "also_broken"} {
+		if status, ok := e.programStatus[name]; !ok || status.err == "" || status.attached {
+			t.Errorf("Expected error recorded for skipped program %q, got %#v", name, status)
+		}
+	}
+
+	if len(e.modules) != 0 {
+		t.Errorf("Expected no modules left after failed attach, got %d", len(e.modules))
+	}
+}
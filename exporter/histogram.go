@@ -3,6 +3,7 @@ package exporter
 import (
 	"fmt"
 	"math"
+	"math/bits"
 
 	"github.com/ahas-sigs/kube-ebpf-exporter/config"
 )
@@ -29,11 +30,64 @@ func histogramKeyerMaker(histogram config.Histogram) (histogramKeyer, error) {
 		return func(bucket float64) float64 {
 			return bucket * multiplier
 		}, nil
+	case config.HistogramBucketLogLinear:
+		subBuckets, err := logLinearSubBuckets(histogram)
+		if err != nil {
+			return nil, err
+		}
+
+		return func(bucket float64) float64 {
+			return logLinearUpperLimit(uint64(bucket), subBuckets) * multiplier
+		}, nil
+	case config.HistogramBucketCustom:
+		if histogram.BucketMin < 0 || histogram.BucketMax >= len(histogram.BucketBoundaries) {
+			return nil, fmt.Errorf("histogram buckets [%d .. %d] are outside of %d bucket_boundaries", histogram.BucketMin, histogram.BucketMax, len(histogram.BucketBoundaries))
+		}
+
+		return func(bucket float64) float64 {
+			return histogram.BucketBoundaries[int(bucket)] * multiplier
+		}, nil
 	default:
 		return nil, fmt.Errorf("unknown histogram type: %q", histogram.BucketType)
 	}
 }
 
+// logLinearSubBuckets returns the number of sub-buckets of log-linear histogram
+func logLinearSubBuckets(histogram config.Histogram) (uint64, error) {
+	subBuckets := histogram.BucketSubBuckets
+	if subBuckets < 1 || subBuckets&(subBuckets-1) != 0 {
+		return 0, fmt.Errorf("bucket_sub_buckets must be a power of two, got %d", subBuckets)
+	}
+
+	return uint64(subBuckets), nil
+}
+
+// logLinearSlot returns the log-linear slot of the value. Values below
+// 2 * subBuckets have a slot of their own, above that every power of two
+// range [2^e .. 2^(e+1)) is split into subBuckets buckets of equal width.
+func logLinearSlot(value uint64, subBuckets uint64) uint64 {
+	if value < subBuckets {
+		return value
+	}
+
+	subBits := uint64(bits.Len64(subBuckets) - 1)
+	shift := uint64(bits.Len64(value)-1) - subBits
+
+	return (shift+1)*subBuckets + (value >> shift) - subBuckets
+}
+
+// logLinearUpperLimit returns the largest value that goes into the slot
+func logLinearUpperLimit(slot uint64, subBuckets uint64) float64 {
+	if slot < subBuckets {
+		return float64(slot)
+	}
+
+	shift := slot/subBuckets - 1
+	sub := slot % subBuckets
+
+	return math.Ldexp(float64(subBuckets+sub+1), int(shift)) - 1
+}
+
 func transformHistogram(buckets map[float64]uint64, histogram config.Histogram) (transformed map[float64]uint64, count uint64, sum float64, err error) {
 	keyer, err := histogramKeyerMaker(histogram)
 	if err != nil {
@@ -47,6 +101,11 @@ func transformHistogram(buckets map[float64]uint64, histogram config.Histogram)
 
 	transformed = make(map[float64]uint64, size)
 
+	// Values below the first bucket are below every upper limit
+	if histogram.UnderflowSlot != nil {
+		count += buckets[float64(*histogram.UnderflowSlot)]
+	}
+
 	// Histograms coming from kernels may have missing entries,
 	// but we must provide consistent view for prometheus.
 	// This is why we build the list of possible buickets from
@@ -59,13 +118,75 @@ func transformHistogram(buckets map[float64]uint64, histogram config.Histogram)
 		transformed[keyer(i)] = count
 	}
 
+	// Values above the last bucket are only in +Inf bucket, which
+	// prometheus fills from the count
+	if histogram.OverflowSlot != nil {
+		count += buckets[float64(*histogram.OverflowSlot)]
+	}
+
 	multiplier := histogram.BucketMultiplier
 	if multiplier == 0 {
 		multiplier = 1
 	}
 
 	// Optional sum key
-	sum = float64(buckets[float64(histogram.BucketMax+1)]) * multiplier
+	sum = float64(buckets[float64(histogram.SumKey())]) * multiplier
 
 	return
 }
+
+// histogramSlot returns the bucket key for the observed value the same way
+// eBPF programs compute it, so that value is never above the upper limit of
+// its bucket. Values outside of [bucket_min .. bucket_max] go to underflow and
+// overflow slots if the histogram has them and are capped otherwise.
+func histogramSlot(value float64, histogram config.Histogram) (float64, error) {
+	slot := 0.0
+
+	switch histogram.BucketType {
+	case config.HistogramBucketExp2:
+		// Same as bpf_log2l(), which gives 2^(slot-1) <= value < 2^slot
+		if value >= 1 {
+			slot = math.Floor(math.Log2(value)) + 1
+		}
+	case config.HistogramBucketLinear:
+		slot = math.Ceil(value)
+	case config.HistogramBucketLogLinear:
+		subBuckets, err := logLinearSubBuckets(histogram)
+		if err != nil {
+			return 0, err
+		}
+
+		if value > 0 {
+			slot = float64(logLinearSlot(uint64(math.Ceil(value)), subBuckets))
+		}
+	case config.HistogramBucketCustom:
+		// The first boundary that is not below the value
+		slot = float64(len(histogram.BucketBoundaries))
+		for i, boundary := range histogram.BucketBoundaries {
+			if value <= boundary {
+				slot = float64(i)
+				break
+			}
+		}
+	default:
+		return 0, fmt.Errorf("unknown histogram type: %q", histogram.BucketType)
+	}
+
+	if slot < float64(histogram.BucketMin) {
+		if histogram.UnderflowSlot != nil {
+			return float64(*histogram.UnderflowSlot), nil
+		}
+
+		slot = float64(histogram.BucketMin)
+	}
+
+	if slot > float64(histogram.BucketMax) {
+		if histogram.OverflowSlot != nil {
+			return float64(*histogram.OverflowSlot), nil
+		}
+
+		slot = float64(histogram.BucketMax)
+	}
+
+	return slot, nil
+}
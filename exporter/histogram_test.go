@@ -0,0 +1,99 @@
+package exporter
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ahas-sigs/kube-ebpf-exporter/config"
+)
+
+func TestTransformHistogram(t *testing.T) {
+	cases := []struct {
+		name      string
+		histogram config.Histogram
+		buckets   map[float64]uint64
+		expected  map[float64]uint64
+		count     uint64
+		sum       float64
+	}{
+		{
+			name:      "exp2",
+			histogram: config.Histogram{BucketType: config.HistogramBucketExp2, BucketMin: 0, BucketMax: 3, BucketMultiplier: 0.5},
+			buckets:   map[float64]uint64{0: 1, 2: 2, 4: 10},
+			expected:  map[float64]uint64{0.5: 1, 1: 1, 2: 3, 4: 3},
+			count:     3,
+			sum:       5,
+		},
+		{
+			name:      "log-linear",
+			histogram: config.Histogram{BucketType: config.HistogramBucketLogLinear, BucketMin: 0, BucketMax: 13, BucketSubBuckets: 4},
+			buckets:   map[float64]uint64{3: 1, 8: 2, 12: 3, 13: 1, 14: 50},
+			expected: map[float64]uint64{
+				0: 0, 1: 0, 2: 0, 3: 1, 4: 1, 5: 1, 6: 1, 7: 1,
+				9: 3, 11: 3, 13: 3, 15: 3, 19: 6, 23: 7,
+			},
+			count: 7,
+			sum:   50,
+		},
+		{
+			name:      "custom",
+			histogram: config.Histogram{BucketType: config.HistogramBucketCustom, BucketMin: 0, BucketMax: 3, BucketBoundaries: []float64{100, 250, 500, 1000}, BucketMultiplier: 0.001},
+			buckets:   map[float64]uint64{0: 4, 2: 1, 3: 1, 4: 900},
+			expected:  map[float64]uint64{0.1: 4, 0.25: 4, 0.5: 5, 1: 6},
+			count:     6,
+			sum:       0.9,
+		},
+		{
+			name: "underflow and overflow",
+			histogram: config.Histogram{
+				BucketType:    config.HistogramBucketExp2,
+				BucketMin:     2,
+				BucketMax:     4,
+				UnderflowSlot: intPtr(0),
+				OverflowSlot:  intPtr(5),
+				SumSlot:       intPtr(6),
+			},
+			buckets:  map[float64]uint64{0: 2, 1: 100, 3: 1, 5: 4, 6: 70},
+			expected: map[float64]uint64{4: 2, 8: 3, 16: 3},
+			count:    7,
+			sum:      70,
+		},
+	}
+
+	for _, c := range cases {
+		transformed, count, sum, err := transformHistogram(c.buckets, c.histogram)
+		if err != nil {
+			t.Fatalf("Error transforming %s histogram: %s", c.name, err)
+		}
+
+		if !reflect.DeepEqual(transformed, c.expected) {
+			t.Errorf("Expected %s buckets %v, got %v", c.name, c.expected, transformed)
+		}
+
+		if count != c.count {
+			t.Errorf("Expected %s count %d, got %d", c.name, c.count, count)
+		}
+
+		if sum != c.sum {
+			t.Errorf("Expected %s sum %v, got %v", c.name, c.sum, sum)
+		}
+	}
+}
+
+func TestTransformHistogramErrors(t *testing.T) {
+	cases := []config.Histogram{
+		{BucketType: config.HistogramBucketLogLinear, BucketMin: 0, BucketMax: 10, BucketSubBuckets: 3},
+		{BucketType: config.HistogramBucketLogLinear, BucketMin: 0, BucketMax: 10},
+		{BucketType: config.HistogramBucketCustom, BucketMin: 0, BucketMax: 4, BucketBoundaries: []float64{1, 2, 3}},
+	}
+
+	for _, histogram := range cases {
+		if _, _, _, err := transformHistogram(map[float64]uint64{}, histogram); err == nil {
+			t.Errorf("Expected error for %#v, got nil", histogram)
+		}
+	}
+}
+
+func intPtr(i int) *int {
+	return &i
+}
@@ -0,0 +1,138 @@
+package exporter
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/ahas-sigs/kube-ebpf-exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// nativeHistogramSchema is the schema of native histograms where every
+// bucket is twice as wide as the previous one, like log2 buckets of eBPF
+const nativeHistogramSchema = 0
+
+// nativeHistogram is a histogram with exponential buckets of native
+// histograms instead of classic buckets, only protobuf exposition
+// carries the buckets, text exposition only has count and sum
+type nativeHistogram struct {
+	prometheus.Metric
+	zeroCount uint64
+	// buckets maps bucket indexes to counts, bucket i holds
+	// values in (2^(i-1), 2^i] with schema 0
+	buckets map[int]uint64
+}
+
+// newNativeHistogram creates a native histogram from exp2 buckets of eBPF table.
+// Slot k of the table holds values below 2^k multiplied by bucket_multiplier,
+// which go to the bucket with the nearest power of two upper limit that is
+// not below 2^k * bucket_multiplier. Slot zero holds zeros.
+func newNativeHistogram(desc *prometheus.Desc, buckets map[float64]uint64, histogram config.Histogram, labelValues ...string) (prometheus.Metric, error) {
+	if histogram.BucketType != config.HistogramBucketExp2 {
+		return nil, fmt.Errorf("native histograms need %q buckets, got %q", config.HistogramBucketExp2, histogram.BucketType)
+	}
+
+	multiplier := histogram.BucketMultiplier
+	if multiplier == 0 {
+		multiplier = 1
+	}
+
+	offset := int(math.Ceil(math.Log2(multiplier)))
+
+	native := &nativeHistogram{buckets: map[int]uint64{}}
+
+	count := uint64(0)
+	for slot := histogram.BucketMin; slot <= histogram.BucketMax; slot++ {
+		value := buckets[float64(slot)]
+		if value == 0 {
+			continue
+		}
+
+		count += value
+
+		if slot == 0 {
+			native.zeroCount += value
+			continue
+		}
+
+		native.buckets[slot+offset] += value
+	}
+
+	// Optional sum key, same as for classic histograms
+	sum := float64(buckets[float64(histogram.SumKey())]) * multiplier
+
+	metric, err := prometheus.NewConstHistogram(desc, count, sum, nil, labelValues...)
+	if err != nil {
+		return nil, err
+	}
+
+	native.Metric = metric
+
+	return native, nil
+}
+
+// Write adds native buckets to the histogram written by the const histogram
+func (h *nativeHistogram) Write(m *dto.Metric) error {
+	if err := h.Metric.Write(m); err != nil {
+		return err
+	}
+
+	schema := int32(nativeHistogramSchema)
+	zeroThreshold := 0.0
+	zeroCount := h.zeroCount
+
+	m.Histogram.Schema = &schema
+	m.Histogram.ZeroThreshold = &zeroThreshold
+	m.Histogram.ZeroCount = &zeroCount
+	m.Histogram.PositiveSpan, m.Histogram.PositiveDelta = nativeHistogramSpans(h.buckets)
+
+	return nil
+}
+
+// nativeHistogramSpans encodes buckets as spans of consecutive buckets
+// and deltas between counts of neighbouring buckets
+func nativeHistogramSpans(buckets map[int]uint64) ([]*dto.BucketSpan, []int64) {
+	indexes := make([]int, 0, len(buckets))
+	for index := range buckets {
+		indexes = append(indexes, index)
+	}
+
+	sort.Ints(indexes)
+
+	// Histograms without buckets need an empty span to be seen as native
+	if len(indexes) == 0 {
+		offset, length := int32(0), uint32(0)
+		return []*dto.BucketSpan{{Offset: &offset, Length: &length}}, nil
+	}
+
+	spans := []*dto.BucketSpan{}
+	deltas := make([]int64, 0, len(indexes))
+
+	previous := int64(0)
+	next := 0
+
+	for i, index := range indexes {
+		// The first span starts at its index, others start after a gap
+		if i == 0 || index != next {
+			offset := int32(index - next)
+			if i == 0 {
+				offset = int32(index)
+			}
+
+			length := uint32(0)
+			spans = append(spans, &dto.BucketSpan{Offset: &offset, Length: &length})
+		}
+
+		*spans[len(spans)-1].Length++
+
+		count := int64(buckets[index])
+		deltas = append(deltas, count-previous)
+		previous = count
+
+		next = index + 1
+	}
+
+	return spans, deltas
+}
@@ -0,0 +1,137 @@
+package exporter
+
+import (
+	"testing"
+
+	"github.com/ahas-sigs/kube-ebpf-exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestNativeHistogram(t *testing.T) {
+	cases := []struct {
+		name      string
+		histogram config.Histogram
+		buckets   map[float64]uint64
+		count     uint64
+		sum       float64
+		zeroCount uint64
+		spans     [][2]int64
+		deltas    []int64
+	}{
+		{
+			name:      "empty",
+			histogram: config.Histogram{BucketType: config.HistogramBucketExp2, BucketMin: 0, BucketMax: 8},
+			buckets:   map[float64]uint64{},
+			spans:     [][2]int64{{0, 0}},
+		},
+		{
+			name:      "consecutive with zeros",
+			histogram: config.Histogram{BucketType: config.HistogramBucketExp2, BucketMin: 0, BucketMax: 8},
+			buckets:   map[float64]uint64{0: 2, 1: 3, 2: 5, 3: 1},
+			count:     11,
+			zeroCount: 2,
+			spans:     [][2]int64{{1, 3}},
+			deltas:    []int64{3, 2, -4},
+		},
+		{
+			name:      "gaps and sum",
+			histogram: config.Histogram{BucketType: config.HistogramBucketExp2, BucketMin: 0, BucketMax: 8},
+			buckets:   map[float64]uint64{2: 1, 3: 2, 6: 4, 9: 100},
+			count:     7,
+			sum:       100,
+			spans:     [][2]int64{{2, 2}, {2, 1}},
+			deltas:    []int64{1, 1, 2},
+		},
+		{
+			name:      "power of two multiplier",
+			histogram: config.Histogram{BucketType: config.HistogramBucketExp2, BucketMin: 0, BucketMax: 8, BucketMultiplier: 0.25},
+			buckets:   map[float64]uint64{3: 1, 4: 1, 9: 8},
+			count:     2,
+			sum:       2,
+			spans:     [][2]int64{{1, 2}},
+			deltas:    []int64{1, 0},
+		},
+		{
+			name:      "microseconds to seconds",
+			histogram: config.Histogram{BucketType: config.HistogramBucketExp2, BucketMin: 0, BucketMax: 26, BucketMultiplier: 0.000001},
+			buckets:   map[float64]uint64{10: 1, 20: 3},
+			count:     4,
+			spans:     [][2]int64{{-9, 1}, {9, 1}},
+			deltas:    []int64{1, 2},
+		},
+	}
+
+	desc := prometheus.NewDesc("latency_seconds", "", []string{"device"}, nil)
+
+	for _, c := range cases {
+		metric, err := newNativeHistogram(desc, c.buckets, c.histogram, "sda")
+		if err != nil {
+			t.Fatalf("Error creating histogram for %q: %s", c.name, err)
+		}
+
+		m := &dto.Metric{}
+		if err := metric.Write(m); err != nil {
+			t.Fatalf("Error writing histogram for %q: %s", c.name, err)
+		}
+
+		h := m.GetHistogram()
+
+		if h.GetSampleCount() != c.count {
+			t.Errorf("Expected count %d for %q, got %d", c.count, c.name, h.GetSampleCount())
+		}
+
+		if h.GetSampleSum() != c.sum {
+			t.Errorf("Expected sum %f for %q, got %f", c.sum, c.name, h.GetSampleSum())
+		}
+
+		if h.GetSchema() != 0 || h.ZeroThreshold == nil {
+			t.Errorf("Expected schema 0 with zero threshold for %q, got schema %d and threshold %v", c.name, h.GetSchema(), h.ZeroThreshold)
+		}
+
+		if h.GetZeroCount() != c.zeroCount {
+			t.Errorf("Expected zero count %d for %q, got %d", c.zeroCount, c.name, h.GetZeroCount())
+		}
+
+		if len(h.GetBucket()) != 0 {
+			t.Errorf("Expected no classic buckets for %q, got %v", c.name, h.GetBucket())
+		}
+
+		spans := [][2]int64{}
+		for _, span := range h.GetPositiveSpan() {
+			spans = append(spans, [2]int64{int64(span.GetOffset()), int64(span.GetLength())})
+		}
+
+		if len(spans) != len(c.spans) {
+			t.Errorf("Expected spans %v for %q, got %v", c.spans, c.name, spans)
+		} else {
+			for i := range spans {
+				if spans[i] != c.spans[i] {
+					t.Errorf("Expected spans %v for %q, got %v", c.spans, c.name, spans)
+					break
+				}
+			}
+		}
+
+		deltas := h.GetPositiveDelta()
+		if len(deltas) != len(c.deltas) {
+			t.Errorf("Expected deltas %v for %q, got %v", c.deltas, c.name, deltas)
+		} else {
+			for i := range deltas {
+				if deltas[i] != c.deltas[i] {
+					t.Errorf("Expected deltas %v for %q, got %v", c.deltas, c.name, deltas)
+					break
+				}
+			}
+		}
+	}
+}
+
+func TestNativeHistogramBucketType(t *testing.T) {
+	desc := prometheus.NewDesc("latency_seconds", "", nil, nil)
+
+	_, err := newNativeHistogram(desc, map[float64]uint64{}, config.Histogram{BucketType: config.HistogramBucketLinear})
+	if err == nil {
+		t.Errorf("Expected error for linear buckets, got nil")
+	}
+}
@@ -0,0 +1,103 @@
+package exporter
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"unsafe"
+
+	"github.com/ahas-sigs/kube-ebpf-exporter/config"
+	"github.com/iovisor/gobpf/bcc"
+	"github.com/iovisor/gobpf/pkg/cpuonline"
+	"golang.org/x/sys/unix"
+)
+
+// attachPerfEvent loads the target of the perf event and attaches it.
+// Perf events on specific cpus or in a cgroup are opened here rather than
+// in bcc, which only attaches to all cpus and once per type and config.
+func (e *Exporter) attachPerfEvent(programName string, module *bcc.Module, perfEvent config.PerfEvent) error {
+	eventType, eventConfig, err := perfEvent.Resolve()
+	if err != nil {
+		return err
+	}
+
+	target, err := module.LoadPerfEvent(perfEvent.Target)
+	if err != nil {
+		return fmt.Errorf("failed to load target %q: %s", perfEvent.Target, err)
+	}
+
+	if len(perfEvent.CPUs) == 0 && perfEvent.Cgroup == "" {
+		return module.AttachPerfEvent(eventType, eventConfig, perfEvent.SamplePeriod, perfEvent.SampleFrequency, -1, -1, -1, target)
+	}
+
+	cpus := perfEvent.CPUs
+	if len(cpus) == 0 {
+		online, err := cpuonline.Get()
+		if err != nil {
+			return fmt.Errorf("failed to determine online cpus: %s", err)
+		}
+
+		for _, cpu := range online {
+			cpus = append(cpus, int(cpu))
+		}
+	}
+
+	pid := -1
+	flags := unix.PERF_FLAG_FD_CLOEXEC
+
+	if perfEvent.Cgroup != "" {
+		cgroup, err := os.Open(perfEvent.Cgroup)
+		if err != nil {
+			return fmt.Errorf("failed to open cgroup: %s", err)
+		}
+
+		// The kernel keeps its own reference to the cgroup
+		defer cgroup.Close()
+
+		pid = int(cgroup.Fd())
+		flags |= unix.PERF_FLAG_PID_CGROUP
+	}
+
+	attr := unix.PerfEventAttr{
+		Type:   uint32(eventType),
+		Config: uint64(eventConfig),
+		Sample: uint64(perfEvent.SamplePeriod),
+	}
+
+	attr.Size = uint32(unsafe.Sizeof(attr))
+
+	if perfEvent.SampleFrequency > 0 {
+		attr.Sample = uint64(perfEvent.SampleFrequency)
+		attr.Bits |= unix.PerfBitFreq
+	}
+
+	for _, cpu := range cpus {
+		fd, err := unix.PerfEventOpen(&attr, pid, cpu, -1, flags)
+		if err != nil {
+			return fmt.Errorf("failed to open perf event on cpu %d: %s", cpu, err)
+		}
+
+		e.perfEventFds[programName] = append(e.perfEventFds[programName], fd)
+
+		if err := unix.IoctlSetInt(fd, unix.PERF_EVENT_IOC_SET_BPF, target); err != nil {
+			return fmt.Errorf("failed to attach target %q on cpu %d: %s", perfEvent.Target, cpu, err)
+		}
+
+		if err := unix.IoctlSetInt(fd, unix.PERF_EVENT_IOC_ENABLE, 0); err != nil {
+			return fmt.Errorf("failed to enable perf event on cpu %d: %s", cpu, err)
+		}
+	}
+
+	return nil
+}
+
+// closePerfEvents closes perf events opened for the program
+func (e *Exporter) closePerfEvents(programName string) {
+	for _, fd := range e.perfEventFds[programName] {
+		if err := unix.Close(fd); err != nil {
+			log.Printf("Error closing perf event of program %q: %s", programName, err)
+		}
+	}
+
+	delete(e.perfEventFds, programName)
+}
@@ -0,0 +1,266 @@
+package exporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/ahas-sigs/kube-ebpf-exporter/config"
+	"github.com/iovisor/gobpf/bcc"
+)
+
+// programStatus is the outcome of attaching a program
+type programStatus struct {
+	attached     bool
+	err          string
+	loadTime     time.Time
+	loadDuration time.Duration
+}
+
+// programProbe is a probe or tracepoint of a program in /programs output
+type programProbe struct {
+	Type     string `json:"type"`
+	Target   string `json:"target"`
+	Function string `json:"function"`
+	Tag      string `json:"tag,omitempty"`
+}
+
+// programTable is a table of a program in /programs output
+type programTable struct {
+	Name    string `json:"name"`
+	Kind    string `json:"kind"`
+	Entries *int   `json:"entries,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// programMetric is a metric of a program in /programs output
+type programMetric struct {
+	Name   string   `json:"name"`
+	Type   string   `json:"type"`
+	Table  string   `json:"table,omitempty"`
+	Event  string   `json:"event,omitempty"`
+	Labels []string `json:"labels"`
+}
+
+// programInfo is a program in /programs output
+type programInfo struct {
+	Name         string          `json:"name"`
+	Attached     bool            `json:"attached"`
+	Error        string          `json:"error,omitempty"`
+	LoadTime     *time.Time      `json:"load_time,omitempty"`
+	LoadDuration string          `json:"load_duration,omitempty"`
+	Cflags       []string        `json:"cflags"`
+	Probes       []programProbe  `json:"probes"`
+	Tables       []programTable  `json:"tables"`
+	Metrics      []programMetric `json:"metrics"`
+}
+
+// programsInfo describes every configured program and its current state
+func (e *Exporter) programsInfo() []programInfo {
+	programs := []programInfo{}
+
+	for _, program := range e.config.Programs {
+		info := programInfo{
+			Name:    program.Name,
+			Cflags:  program.Cflags,
+			Probes:  []programProbe{},
+			Tables:  []programTable{},
+			Metrics: []programMetric{},
+		}
+
+		if info.Cflags == nil {
+			info.Cflags = []string{}
+		}
+
+		if status, ok := e.programStatus[program.Name]; ok {
+			info.Attached = status.attached
+			info.Error = status.err
+
+			if !status.loadTime.IsZero() {
+				loadTime := status.loadTime
+				info.LoadTime = &loadTime
+				info.LoadDuration = status.loadDuration.String()
+			}
+		}
+
+		tags := e.programTags[program.Name]
+
+		addProbes := func(probeType string, probes map[string]string) {
+			for target, function := range probes {
+				probe := programProbe{
+					Type:     probeType,
+					Target:   target,
+					Function: function,
+				}
+
+				if tag, ok := tags[function]; ok {
+					probe.Tag = fmt.Sprintf("%x", tag)
+				}
+
+				info.Probes = append(info.Probes, probe)
+			}
+		}
+
+		addProbes("kprobe", program.Kprobes)
+		addProbes("kretprobe", program.Kretprobes)
+		addProbes("tracepoint", program.Tracepoints)
+		addProbes("raw_tracepoint", program.RawTracepoints)
+
+		for _, perfEvent := range program.PerfEvents {
+			info.Probes = append(info.Probes, programProbe{
+				Type:     "perf_event",
+				Target:   fmt.Sprintf("%s:%s", perfEvent.Type, perfEvent.Name),
+				Function: perfEvent.Target,
+			})
+		}
+
+		sort.SliceStable(info.Probes, func(i, j int) bool {
+			if info.Probes[i].Type != info.Probes[j].Type {
+				return info.Probes[i].Type < info.Probes[j].Type
+			}
+			return info.Probes[i].Target < info.Probes[j].Target
+		})
+
+		module := e.modules[program.Name]
+		seen := map[string]bool{}
+
+		addTable := func(name string, kind string) {
+			if name == "" || seen[name] {
+				return
+			}
+
+			seen[name] = true
+
+			table := programTable{Name: name, Kind: kind}
+
+			// Perf output tables have no entries to count
+			if module != nil && kind == "metrics" {
+				entries, err := tableEntries(module, name)
+				if err != nil {
+					table.Error = err.Error()
+				} else {
+					table.Entries = &entries
+				}
+			}
+
+			info.Tables = append(info.Tables, table)
+		}
+
+		labelNames := func(labels []config.Label) []string {
+			names := make([]string, len(labels))
+			for i, label := range labels {
+				names[i] = label.Name
+			}
+			return names
+		}
+
+		for _, counter := range program.Metrics.Counters {
+			addTable(counter.Table, "metrics")
+
+			info.Metrics = append(info.Metrics, programMetric{
+				Name:   counter.Name,
+				Type:   "counter",
+				Table:  counter.Table,
+				Event:  counter.Event,
+				Labels: labelNames(counter.Labels),
+			})
+		}
+
+		for _, histogram := range program.Metrics.Histograms {
+			addTable(histogram.Table, "metrics")
+
+			info.Metrics = append(info.Metrics, programMetric{
+				Name:   histogram.Name,
+				Type:   "histogram",
+				Table:  histogram.Table,
+				Event:  histogram.Event,
+				Labels: labelNames(histogram.Labels),
+			})
+		}
+
+		for _, event := range program.Events {
+			addTable(event.Table, "events")
+		}
+
+		programs = append(programs, info)
+	}
+
+	return programs
+}
+
+// tableEntries returns the number of entries in the table
+func tableEntries(module *bcc.Module, tableName string) (int, error) {
+	if !tableExists(module, tableName) {
+		return 0, fmt.Errorf("table %q is not found", tableName)
+	}
+
+	iter := bcc.NewTable(module.TableId(tableName), module).Iter()
+
+	entries := 0
+	for iter.Next() {
+		entries++
+	}
+
+	return entries, iter.Err()
+}
+
+var programsTemplate = template.Must(template.New("programs").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Programs</title></head>
+<body>
+<h1>Programs</h1>
+{{range .}}
+<h2>{{.Name}}</h2>
+<p>
+{{if .Attached}}Attached{{else}}Not attached{{end}}
+{{if .LoadTime}} at {{.LoadTime.Format "2006-01-02T15:04:05Z07:00"}} in {{.LoadDuration}}{{end}}
+</p>
+{{if .Error}}<p>Error: <code>{{.Error}}</code></p>{{end}}
+<p>Cflags: {{range .Cflags}}<code>{{.}}</code> {{else}}none{{end}}</p>
+<h3>Probes</h3>
+<table border="1">
+<tr><th>Type</th><th>Target</th><th>Function</th><th>Tag</th></tr>
+{{range .Probes}}<tr><td>{{.Type}}</td><td>{{.Target}}</td><td>{{.Function}}</td><td>{{.Tag}}</td></tr>
+{{end}}</table>
+<h3>Tables</h3>
+<table border="1">
+<tr><th>Name</th><th>Kind</th><th>Entries</th></tr>
+{{range .Tables}}<tr><td>{{.Name}}</td><td>{{.Kind}}</td><td>{{if .Error}}{{.Error}}{{else if .Entries}}{{.Entries}}{{end}}</td></tr>
+{{end}}</table>
+<h3>Metrics</h3>
+<table border="1">
+<tr><th>Name</th><th>Type</th><th>Source</th><th>Labels</th></tr>
+{{range .Metrics}}<tr><td>{{.Name}}</td><td>{{.Type}}</td><td>{{if .Event}}event {{.Event}}{{else}}table {{.Table}}{{end}}</td><td>{{range .Labels}}{{.}} {{end}}</td></tr>
+{{end}}</table>
+{{end}}
+</body>
+</html>
+`))
+
+// ProgramsHandler describes configured programs and their state,
+// use format=json query parameter to get structured output
+func (e *Exporter) ProgramsHandler(w http.ResponseWriter, r *http.Request) {
+	programs := e.programsInfo()
+
+	var err error
+
+	switch r.URL.Query().Get("format") {
+	case "json":
+		w.Header().Add("Content-type", "application/json")
+		err = json.NewEncoder(w).Encode(programs)
+	case "", "html":
+		w.Header().Add("Content-type", "text/html")
+		err = programsTemplate.Execute(w, programs)
+	default:
+		http.Error(w, fmt.Sprintf("unknown format %q", r.URL.Query().Get("format")), http.StatusBadRequest)
+		return
+	}
+
+	if err != nil {
+		log.Printf("Error returning programs to client %q: %s", r.RemoteAddr, err)
+	}
+}
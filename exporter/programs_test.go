@@ -0,0 +1,85 @@
+package exporter
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ahas-sigs/kube-ebpf-exporter/config"
+)
+
+func TestProgramsHandler(t *testing.T) {
+	e := &Exporter{
+		config: config.Config{
+			Programs: []config.Program{
+				{
+					Name:        "bio",
+					Cflags:      []string{"-DMAX=1"},
+					Kprobes:     map[string]string{"blk_start_request": "trace_req_start"},
+					Tracepoints: map[string]string{"block:block_rq_complete": "tracepoint__block__block_rq_complete"},
+					Metrics: config.Metrics{
+						Histograms: []config.Histogram{
+							{Name: "bio_latency_seconds", Table: "io_latency", Labels: []config.Label{{Name: "device"}, {Name: "bucket"}}},
+						},
+					},
+					Events: []config.Event{
+						{Name: "slow_io", Table: "slow_ios"},
+					},
+				},
+				{
+					Name: "broken",
+				},
+			},
+		},
+		programTags: map[string]map[string]uint64{
+			"bio": {"trace_req_start": 0xabc},
+		},
+		programStatus: map[string]*programStatus{
+			"bio":    {attached: true, loadTime: time.Date(2020, 4, 14, 15, 0, 0, 0, time.UTC), loadDuration: time.Second},
+			"broken": {err: "error compiling module for program \"broken\""},
+		},
+	}
+
+	w := httptest.NewRecorder()
+	e.ProgramsHandler(w, httptest.NewRequest("GET", "/programs?format=json", nil))
+
+	programs := []programInfo{}
+	if err := json.Unmarshal(w.Body.Bytes(), &programs); err != nil {
+		t.Fatalf("Error unmarshaling programs %s: %s", w.Body.String(), err)
+	}
+
+	if len(programs) != 2 {
+		t.Fatalf("Expected 2 programs, got %d", len(programs))
+	}
+
+	bio := programs[0]
+
+	if !bio.Attached || bio.LoadDuration != "1s" || len(bio.Cflags) != 1 {
+		t.Errorf("Unexpected program state: %#v", bio)
+	}
+
+	if len(bio.Probes) != 2 || bio.Probes[0].Type != "kprobe" || bio.Probes[0].Tag != "abc" || bio.Probes[1].Tag != "" {
+		t.Errorf("Unexpected probes: %#v", bio.Probes)
+	}
+
+	if len(bio.Tables) != 2 || bio.Tables[0].Kind != "metrics" || bio.Tables[1].Kind != "events" {
+		t.Errorf("Unexpected tables: %#v", bio.Tables)
+	}
+
+	if len(bio.Metrics) != 1 || bio.Metrics[0].Type != "histogram" || bio.Metrics[0].Table != "io_latency" {
+		t.Errorf("Unexpected metrics: %#v", bio.Metrics)
+	}
+
+	if programs[1].Attached || programs[1].Error == "" || programs[1].LoadTime != nil {
+		t.Errorf("Expected failed program, got %#v", programs[1])
+	}
+
+	w = httptest.NewRecorder()
+	e.ProgramsHandler(w, httptest.NewRequest("GET", "/programs", nil))
+
+	if !strings.Contains(w.Body.String(), "error compiling module for program &#34;broken&#34;") {
+		t.Errorf("Expected escaped error in html output, got %s", w.Body.String())
+	}
+}
@@ -0,0 +1,67 @@
+package exporter
+
+import (
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/ahas-sigs/kube-ebpf-exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// histogramQuantiles returns gauges with estimated quantiles of the histogram
+func histogramQuantiles(desc *prometheus.Desc, buckets map[float64]uint64, histogram config.Histogram, labelValues ...string) ([]prometheus.Metric, error) {
+	transformed, count, _, err := transformHistogram(buckets, histogram)
+	if err != nil {
+		return nil, err
+	}
+
+	metrics := make([]prometheus.Metric, 0, len(histogram.Quantiles))
+
+	for _, q := range histogram.Quantiles {
+		values := append(append([]string{}, labelValues...), strconv.FormatFloat(q, 'g', -1, 64))
+
+		metric, err := prometheus.NewConstMetric(desc, prometheus.GaugeValue, histogramQuantile(q, transformed, count), values...)
+		if err != nil {
+			return nil, err
+		}
+
+		metrics = append(metrics, metric)
+	}
+
+	return metrics, nil
+}
+
+// histogramQuantile estimates the quantile from cumulative buckets the same
+// way histogram_quantile() of prometheus does: the value is interpolated
+// linearly within the bucket where the quantile is. Quantiles in +Inf bucket
+// are estimated as the upper limit of the last finite bucket.
+func histogramQuantile(q float64, buckets map[float64]uint64, count uint64) float64 {
+	if count == 0 || len(buckets) == 0 {
+		return math.NaN()
+	}
+
+	limits := make([]float64, 0, len(buckets))
+	for limit := range buckets {
+		limits = append(limits, limit)
+	}
+
+	sort.Float64s(limits)
+
+	rank := q * float64(count)
+
+	// The first bucket starts at zero unless its limit is below zero
+	lower, below := math.Min(0, limits[0]), uint64(0)
+
+	for _, limit := range limits {
+		cumulative := buckets[limit]
+
+		if float64(cumulative) >= rank && cumulative > below {
+			return lower + (limit-lower)*(rank-float64(below))/float64(cumulative-below)
+		}
+
+		lower, below = limit, cumulative
+	}
+
+	return lower
+}
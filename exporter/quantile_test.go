@@ -0,0 +1,92 @@
+package exporter
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ahas-sigs/kube-ebpf-exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestHistogramQuantile(t *testing.T) {
+	// 10 values in (0 .. 1], 10 in (1 .. 2], 20 in (2 .. 4] and 10 above 4
+	buckets := map[float64]uint64{1: 10, 2: 20, 4: 40}
+
+	cases := []struct {
+		q        float64
+		count    uint64
+		expected float64
+	}{
+		{0, 50, 0},
+		{0.1, 50, 0.5},
+		{0.3, 50, 1.5},
+		{0.6, 50, 3},
+		{0.8, 50, 4},
+		{0.99, 50, 4},
+		{0.5, 40, 2},
+	}
+
+	for _, c := range cases {
+		quantile := histogramQuantile(c.q, buckets, c.count)
+		if quantile != c.expected {
+			t.Errorf("Expected quantile %v of %d values to be %v, got %v", c.q, c.count, c.expected, quantile)
+		}
+	}
+
+	if quantile := histogramQuantile(0.5, map[float64]uint64{1: 0, 2: 0}, 0); !math.IsNaN(quantile) {
+		t.Errorf("Expected NaN quantile for empty histogram, got %v", quantile)
+	}
+}
+
+func TestHistogramQuantiles(t *testing.T) {
+	desc := prometheus.NewDesc("latency_seconds_quantile", "", []string{"device", "quantile"}, nil)
+
+	histogram := config.Histogram{
+		BucketType:       config.HistogramBucketExp2,
+		BucketMin:        0,
+		BucketMax:        3,
+		BucketMultiplier: 0.5,
+		Quantiles:        []float64{0.5, 0.99},
+	}
+
+	// 0.5: 2 values, 1: 2 values, 2: 0 values, 4: 4 values
+	buckets := map[float64]uint64{0: 2, 1: 2, 3: 4}
+
+	metrics, err := histogramQuantiles(desc, buckets, histogram, "sda")
+	if err != nil {
+		t.Fatalf("Error estimating quantiles: %s", err)
+	}
+
+	expected := []struct {
+		quantile string
+		value    float64
+	}{
+		{"0.5", 1},
+		{"0.99", 3.96},
+	}
+
+	if len(metrics) != len(expected) {
+		t.Fatalf("Expected %d quantiles, got %d", len(expected), len(metrics))
+	}
+
+	for i, metric := range metrics {
+		m := &dto.Metric{}
+		if err := metric.Write(m); err != nil {
+			t.Fatalf("Error writing quantile: %s", err)
+		}
+
+		labels := map[string]string{}
+		for _, label := range m.GetLabel() {
+			labels[label.GetName()] = label.GetValue()
+		}
+
+		if labels["device"] != "sda" || labels["quantile"] != expected[i].quantile {
+			t.Errorf("Expected labels device=sda and quantile=%s, got %v", expected[i].quantile, labels)
+		}
+
+		if math.Abs(m.GetGauge().GetValue()-expected[i].value) > 1e-9 {
+			t.Errorf("Expected quantile %s to be %v, got %v", expected[i].quantile, expected[i].value, m.GetGauge().GetValue())
+		}
+	}
+}
@@ -0,0 +1,332 @@
+package exporter
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ahas-sigs/kube-ebpf-exporter/config"
+)
+
+const (
+	// sinkDefaultRotateInterval is used when rotate_interval is not set
+	sinkDefaultRotateInterval = time.Hour
+	// sinkDefaultFlushInterval is used when flush_interval is not set
+	sinkDefaultFlushInterval = 10 * time.Second
+	// sinkDefaultQueueSize is used when queue_size is not set
+	sinkDefaultQueueSize = 5000
+	// sinkDefaultBlockTimeout is used when block_timeout is not set
+	sinkDefaultBlockTimeout = time.Second
+	// sinkFileSuffix is the suffix of every sink file in the sink root
+	sinkFileSuffix = ".gz"
+)
+
+// sinkWriter writes sink records into gzip files in the sink root,
+// rotating them by time and size and removing old files according
+// to the configured retention. Each file is a single gzip stream
+// that is kept open for the whole rotation window.
+type sinkWriter struct {
+	mu     sync.Mutex
+	root   string
+	config config.Sink
+	level  int
+
+	file         *os.File
+	counter      *countingWriter
+	gz           *gzip.Writer
+	buf          *bufio.Writer
+	path         string
+	window       time.Time
+	seq          int
+	bytesTotal   uint64
+	removedTotal uint64
+}
+
+// newSinkWriter creates a sinkWriter for the provided root directory
+func newSinkWriter(root string, conf config.Sink) *sinkWriter {
+	if conf.RotateInterval <= 0 {
+		conf.RotateInterval = sinkDefaultRotateInterval
+	}
+
+	if conf.FlushInterval <= 0 {
+		conf.FlushInterval = sinkDefaultFlushInterval
+	}
+
+	level := gzip.DefaultCompression
+	if conf.CompressionLevel != nil {
+		level = *conf.CompressionLevel
+	}
+
+	return &sinkWriter{
+		root:   root,
+		config: conf,
+		level:  level,
+	}
+}
+
+// sinkQueueDefaults fills in defaults for the sink queue settings
+// and rejects overflow policies it does not know how to apply
+func sinkQueueDefaults(conf config.Sink) (config.Sink, error) {
+	if conf.QueueSize <= 0 {
+		conf.QueueSize = sinkDefaultQueueSize
+	}
+
+	switch conf.OverflowPolicy {
+	case "":
+		conf.OverflowPolicy = config.SinkOverflowDropOldest
+	case config.SinkOverflowDropOldest, config.SinkOverflowDropNewest, config.SinkOverflowBlock:
+	default:
+		return conf, fmt.Errorf("unknown sink overflow_policy %q", conf.OverflowPolicy)
+	}
+
+	if conf.BlockTimeout <= 0 {
+		conf.BlockTimeout = sinkDefaultBlockTimeout
+	}
+
+	return conf, nil
+}
+
+// write appends records to the current sink file and reports whether
+// a new file was opened to write them
+func (s *sinkWriter) write(records []string, now time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rotated, err := s.rotateIfNeeded(now)
+	if err != nil {
+		return rotated, err
+	}
+
+	for _, data := range records {
+		if _, err = s.buf.WriteString(data); err != nil {
+			return rotated, fmt.Errorf("write %s fail, %s", s.path, err)
+		}
+	}
+
+	return rotated, nil
+}
+
+// flush pushes buffered records to the current sink file, so readers
+// can decompress everything written so far, and closes the file once
+// its rotation window has passed. Retention is enforced on every flush,
+// so that limits hold between rotations that can be an hour apart.
+func (s *sinkWriter) flush(now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.flushFile(now)
+
+	s.enforceRetention(now)
+
+	return err
+}
+
+// flushFile flushes or closes the current sink file if there is one
+func (s *sinkWriter) flushFile(now time.Time) error {
+	if s.file == nil {
+		return nil
+	}
+
+	if !now.Truncate(s.config.RotateInterval).Equal(s.window) {
+		return s.closeFile()
+	}
+
+	if err := s.buf.Flush(); err != nil {
+		return fmt.Errorf("flush %s fail, %s", s.path, err)
+	}
+
+	if err := s.gz.Flush(); err != nil {
+		return fmt.Errorf("flush %s fail, %s", s.path, err)
+	}
+
+	return nil
+}
+
+// close finishes the gzip stream and closes the current sink file
+func (s *sinkWriter) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.closeFile()
+}
+
+// rotateIfNeeded opens a new sink file when there is no file yet, the
+// rotation window has passed or the current file has grown too large
+func (s *sinkWriter) rotateIfNeeded(now time.Time) (bool, error) {
+	window := now.Truncate(s.config.RotateInterval)
+
+	switch {
+	case s.file == nil:
+		s.seq = 0
+	case !window.Equal(s.window):
+		s.seq = 0
+	case s.config.RotateSize > 0 && s.counter.n >= s.config.RotateSize:
+		s.seq++
+	default:
+		return false, nil
+	}
+
+	if err := s.closeFile(); err != nil {
+		log.Printf("close %s fail, %s", s.path, err)
+	}
+
+	if err := os.MkdirAll(s.root, 0777); err != nil {
+		return false, err
+	}
+
+	s.window = window
+
+	// Files from previous runs are never appended to, because that
+	// would produce a multi-member gzip file
+	for {
+		s.path = s.filePath()
+		if _, err := os.Stat(s.path); os.IsNotExist(err) {
+			break
+		}
+		s.seq++
+	}
+
+	fl, err := os.OpenFile(s.path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0777)
+	if err != nil {
+		return false, fmt.Errorf("open %s fail, %s", s.path, err)
+	}
+
+	counter := &countingWriter{w: fl, total: &s.bytesTotal}
+
+	gz, err := gzip.NewWriterLevel(counter, s.level)
+	if err != nil {
+		fl.Close()
+		return false, fmt.Errorf("new gzip writer %s fail, %s", s.path, err)
+	}
+
+	s.file = fl
+	s.counter = counter
+	s.gz = gz
+	s.buf = bufio.NewWriter(gz)
+
+	s.enforceRetention(now)
+
+	return true, nil
+}
+
+// filePath returns the path for the current window and sequence number
+func (s *sinkWriter) filePath() string {
+	layout := "2006010215"
+	if s.config.RotateInterval%time.Hour != 0 {
+		layout = "200601021504"
+	}
+
+	name := s.window.Local().Format(layout)
+	if s.seq > 0 {
+		name = fmt.Sprintf("%s.%d", name, s.seq)
+	}
+
+	return filepath.Join(s.root, name+sinkFileSuffix)
+}
+
+// closeFile finishes the gzip stream, syncs (if configured)
+// and closes the current sink file
+func (s *sinkWriter) closeFile() error {
+	if s.file == nil {
+		return nil
+	}
+
+	fl := s.file
+	s.file = nil
+
+	err := s.buf.Flush()
+
+	if cerr := s.gz.Close(); err == nil {
+		err = cerr
+	}
+
+	if s.config.FsyncOnRotate && err == nil {
+		err = fl.Sync()
+	}
+
+	if cerr := fl.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// enforceRetention removes sink files that are older than max_age and
+// then the oldest files until the total size fits into max_total_bytes
+func (s *sinkWriter) enforceRetention(now time.Time) {
+	if s.config.MaxAge <= 0 && s.config.MaxTotalBytes <= 0 {
+		return
+	}
+
+	entries, err := ioutil.ReadDir(s.root)
+	if err != nil {
+		log.Printf("read sink root %s fail, %s", s.root, err)
+		return
+	}
+
+	files := []os.FileInfo{}
+	total := int64(0)
+	if s.counter != nil {
+		total = s.counter.n
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), sinkFileSuffix) {
+			continue
+		}
+
+		if filepath.Join(s.root, entry.Name()) == s.path {
+			continue
+		}
+
+		files = append(files, entry)
+		total += entry.Size()
+	}
+
+	// Oldest files go first
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().Before(files[j].ModTime())
+	})
+
+	for _, file := range files {
+		expired := s.config.MaxAge > 0 && now.Sub(file.ModTime()) > s.config.MaxAge
+		oversize := s.config.MaxTotalBytes > 0 && total > s.config.MaxTotalBytes
+
+		if !expired && !oversize {
+			break
+		}
+
+		path := filepath.Join(s.root, file.Name())
+		if err := os.Remove(path); err != nil {
+			log.Printf("remove %s fail, %s", path, err)
+			continue
+		}
+
+		total -= file.Size()
+		atomic.AddUint64(&s.removedTotal, 1)
+	}
+}
+
+// countingWriter counts bytes written through it
+type countingWriter struct {
+	w     io.Writer
+	n     int64
+	total *uint64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	atomic.AddUint64(c.total, uint64(n))
+	return n, err
+}
@@ -0,0 +1,350 @@
+package exporter
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ahas-sigs/kube-ebpf-exporter/config"
+)
+
+func TestSinkWriterRotation(t *testing.T) {
+	root, err := ioutil.TempDir("", "sink")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(root)
+
+	s := newSinkWriter(root, config.Sink{RotateInterval: time.Hour, RotateSize: 1})
+
+	start := time.Date(2020, 4, 14, 15, 0, 0, 0, time.Local)
+
+	cases := []struct {
+		now     time.Time
+		rotated bool
+		name    string
+	}{
+		{now: start, rotated: true, name: "2020041415.gz"},
+		{now: start.Add(time.Minute), rotated: true, name: "2020041415.1.gz"},
+		{now: start.Add(time.Hour), rotated: true, name: "2020041416.gz"},
+	}
+
+	for _, c := range cases {
+		rotated, err := s.write([]string{"{}\n"}, c.now)
+		if err != nil {
+			t.Fatalf("Error writing records: %s", err)
+		}
+
+		if rotated != c.rotated {
+			t.Errorf("Expected rotated to be %v at %s, got %v", c.rotated, c.now, rotated)
+		}
+
+		if filepath.Base(s.path) != c.name {
+			t.Errorf("Expected file %s at %s, got %s", c.name, c.now, filepath.Base(s.path))
+		}
+
+		if err := s.flush(c.now); err != nil {
+			t.Fatalf("Error flushing records: %s", err)
+		}
+	}
+
+	if err := s.close(); err != nil {
+		t.Fatalf("Error closing sink: %s", err)
+	}
+
+	// Restarting in the same window must not append to existing files
+	s = newSinkWriter(root, config.Sink{RotateInterval: time.Hour})
+
+	if _, err := s.write([]string{"{}\n"}, start.Add(time.Hour)); err != nil {
+		t.Fatalf("Error writing records: %s", err)
+	}
+
+	if filepath.Base(s.path) != "2020041416.1.gz" {
+		t.Errorf("Expected file 2020041416.1.gz after restart, got %s", filepath.Base(s.path))
+	}
+}
+
+func TestSinkWriterNoCompression(t *testing.T) {
+	root, err := ioutil.TempDir("", "sink")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(root)
+
+	level := gzip.NoCompression
+
+	s := newSinkWriter(root, config.Sink{CompressionLevel: &level})
+
+	if _, err := s.write([]string{"uncompressed record\n"}, time.Now()); err != nil {
+		t.Fatalf("Error writing records: %s", err)
+	}
+
+	if err := s.close(); err != nil {
+		t.Fatalf("Error closing sink: %s", err)
+	}
+
+	data, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		t.Fatalf("Error reading %s: %s", s.path, err)
+	}
+
+	// Stored blocks keep records as they are
+	if !strings.Contains(string(data), "uncompressed record") {
+		t.Errorf("Expected records to be stored without compression, got %q", data)
+	}
+}
+
+func TestSinkWriterSingleStream(t *testing.T) {
+	root, err := ioutil.TempDir("", "sink")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(root)
+
+	s := newSinkWriter(root, config.Sink{})
+
+	now := time.Now()
+
+	for _, records := range [][]string{{"a\n", "b\n"}, {"c\n"}} {
+		if _, err := s.write(records, now); err != nil {
+			t.Fatalf("Error writing records: %s", err)
+		}
+
+		if err := s.flush(now); err != nil {
+			t.Fatalf("Error flushing records: %s", err)
+		}
+	}
+
+	if err := s.close(); err != nil {
+		t.Fatalf("Error closing sink: %s", err)
+	}
+
+	fl, err := os.Open(s.path)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", s.path, err)
+	}
+	defer fl.Close()
+
+	gr, err := gzip.NewReader(fl)
+	if err != nil {
+		t.Fatalf("Error reading gzip header: %s", err)
+	}
+
+	gr.Multistream(false)
+
+	data, err := ioutil.ReadAll(gr)
+	if err != nil {
+		t.Fatalf("Error reading gzip stream: %s", err)
+	}
+
+	if string(data) != "a\nb\nc\n" {
+		t.Errorf("Expected %q, got %q", "a\nb\nc\n", data)
+	}
+
+	if err := gr.Reset(fl); err != io.EOF {
+		t.Errorf("Expected a single gzip member, got %v after the first one", err)
+	}
+
+	if s.bytesTotal == 0 {
+		t.Errorf("Expected written bytes to be accounted")
+	}
+}
+
+func TestSinkWriterRetention(t *testing.T) {
+	root, err := ioutil.TempDir("", "sink")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(root)
+
+	now := time.Now()
+
+	old := map[string]time.Duration{
+		"2020041411.gz": 4 * time.Hour,
+		"2020041412.gz": 3 * time.Hour,
+		"2020041413.gz": 2 * time.Hour,
+		"unrelated.txt": 5 * time.Hour,
+	}
+
+	for name, age := range old {
+		path := filepath.Join(root, name)
+		if err := ioutil.WriteFile(path, make([]byte, 100), 0644); err != nil {
+			t.Fatalf("Error writing %s: %s", path, err)
+		}
+
+		if err := os.Chtimes(path, now.Add(-age), now.Add(-age)); err != nil {
+			t.Fatalf("Error changing times of %s: %s", path, err)
+		}
+	}
+
+	s := newSinkWriter(root, config.Sink{MaxAge: 150 * time.Minute, MaxTotalBytes: 150})
+
+	s.enforceRetention(now)
+
+	for name, kept := range map[string]bool{
+		"2020041411.gz": false,
+		"2020041412.gz": false,
+		"2020041413.gz": true,
+		"unrelated.txt": true,
+	} {
+		_, err := os.Stat(filepath.Join(root, name))
+		if kept != (err == nil) {
+			t.Errorf("Expected %s to be kept: %v, stat error: %v", name, kept, err)
+		}
+	}
+
+	if s.removedTotal != 2 {
+		t.Errorf("Expected 2 files removed, got %d", s.removedTotal)
+	}
+}
+
+func TestSinkWriterRetentionOnFlush(t *testing.T) {
+	root, err := ioutil.TempDir("", "sink")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(root)
+
+	now := time.Date(2020, 4, 14, 10, 0, 0, 0, time.UTC)
+
+	old := filepath.Join(root, "2020041409.gz")
+	if err := ioutil.WriteFile(old, make([]byte, 100), 0644); err != nil {
+		t.Fatalf("Error writing %s: %s", old, err)
+	}
+
+	if err := os.Chtimes(old, now.Add(-10*time.Minute), now.Add(-10*time.Minute)); err != nil {
+		t.Fatalf("Error changing times of %s: %s", old, err)
+	}
+
+	s := newSinkWriter(root, config.Sink{RotateInterval: 24 * time.Hour, MaxAge: 30 * time.Minute})
+
+	if _, err := s.write([]string{"record\n"}, now); err != nil {
+		t.Fatalf("Error writing records: %s", err)
+	}
+
+	if _, err := os.Stat(old); err != nil {
+		t.Fatalf("Expected %s to be kept while it is younger than max_age: %s", old, err)
+	}
+
+	// The file is still in its rotation window an hour later
+	if err := s.flush(now.Add(time.Hour)); err != nil {
+		t.Fatalf("Error flushing: %s", err)
+	}
+
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Errorf("Expected %s to be removed on flush without rotation, stat error: %v", old, err)
+	}
+
+	if s.file == nil {
+		t.Errorf("Expected current file to stay open without rotation")
+	}
+
+	s.close()
+}
+
+func TestExporterCloseDrainsSink(t *testing.T) {
+	root, err := ioutil.TempDir("", "sink")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(root)
+
+	e := &Exporter{
+		sinkChan: make(chan []string, 10),
+		sinkDone: make(chan struct{}),
+		sink:     newSinkWriter(root, config.Sink{FlushInterval: time.Hour}),
+		events:   newCloudEventer(config.CloudEvents{File: filepath.Join(root, "event.dat")}, nil),
+	}
+
+	for i := 0; i < 5; i++ {
+		e.enqueueSinkValues([]string{"record\n"})
+	}
+
+	go e.dumpSinkValues()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := e.Close(ctx); err != nil {
+		t.Fatalf("Error closing exporter: %s", err)
+	}
+
+	// Values enqueued after close are ignored
+	e.enqueueSinkValues([]string{"late\n"})
+
+	fl, err := os.Open(e.sink.path)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", e.sink.path, err)
+	}
+	defer fl.Close()
+
+	gr, err := gzip.NewReader(fl)
+	if err != nil {
+		t.Fatalf("Error reading gzip header: %s", err)
+	}
+
+	data, err := ioutil.ReadAll(gr)
+	if err != nil {
+		t.Fatalf("Error reading gzip stream: %s", err)
+	}
+
+	if string(data) != strings.Repeat("record\n", 5) {
+		t.Errorf("Expected all queued records to be written, got %q", data)
+	}
+}
+
+func TestEnqueueSinkValuesOverflow(t *testing.T) {
+	cases := []struct {
+		policy  config.SinkOverflow
+		queued  string
+		dropped uint64
+	}{
+		{policy: config.SinkOverflowDropOldest, queued: "second", dropped: 2},
+		{policy: config.SinkOverflowDropNewest, queued: "first", dropped: 1},
+		{policy: config.SinkOverflowBlock, queued: "first", dropped: 1},
+	}
+
+	for _, c := range cases {
+		sink, err := sinkQueueDefaults(config.Sink{OverflowPolicy: c.policy, BlockTimeout: time.Millisecond})
+		if err != nil {
+			t.Fatalf("Error applying sink defaults with %s policy: %s", c.policy, err)
+		}
+
+		e := &Exporter{
+			config:   config.Config{Sink: sink},
+			sinkChan: make(chan []string, 1),
+		}
+
+		e.enqueueSinkValues([]string{"first", "first"})
+		e.enqueueSinkValues([]string{"second"})
+
+		if queued := <-e.sinkChan; queued[0] != c.queued {
+			t.Errorf("Expected %q to be queued with %s policy, got %q", c.queued, c.policy, queued[0])
+		}
+
+		if e.sinkDropped != c.dropped {
+			t.Errorf("Expected %d records dropped with %s policy, got %d", c.dropped, c.policy, e.sinkDropped)
+		}
+	}
+}
+
+func TestSinkQueueDefaultsUnknownPolicy(t *testing.T) {
+	if _, err := sinkQueueDefaults(config.Sink{OverflowPolicy: "drop-latest"}); err == nil {
+		t.Errorf("Expected error for unknown overflow policy, got nil")
+	}
+
+	sink, err := sinkQueueDefaults(config.Sink{})
+	if err != nil {
+		t.Fatalf("Error applying sink defaults: %s", err)
+	}
+
+	if sink.OverflowPolicy != config.SinkOverflowDropOldest {
+		t.Errorf("Expected default overflow policy %q, got %q", config.SinkOverflowDropOldest, sink.OverflowPolicy)
+	}
+}
@@ -0,0 +1,211 @@
+package exporter
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/ahas-sigs/kube-ebpf-exporter/config"
+)
+
+// exportedTable is a kernel map with labels used to decode its keys
+type exportedTable struct {
+	labels []config.Label
+	values []metricValue
+}
+
+// tableEntryJSON is a kernel map row in /tables?format=json output
+type tableEntryJSON struct {
+	Key    string            `json:"key"`
+	KeyHex string            `json:"key_hex,omitempty"`
+	Labels map[string]string `json:"labels"`
+	Value  float64           `json:"value"`
+}
+
+// tableJSON is a kernel map in /tables?format=json output
+type tableJSON struct {
+	Program string           `json:"program"`
+	Table   string           `json:"table"`
+	Labels  []string         `json:"labels"`
+	Entries []tableEntryJSON `json:"entries"`
+}
+
+// exportTables returns decoded values of tables used by metrics,
+// empty program or table names match any of them
+func (e *Exporter) exportTables(programName string, tableName string) (map[string]map[string]exportedTable, error) {
+	tables := map[string]map[string]exportedTable{}
+
+	for _, program := range e.config.Programs {
+		if programName != "" && program.Name != programName {
+			continue
+		}
+
+		// Only a program asked for by name makes a missing module an error,
+		// programs that failed to attach are skipped when listing all of them
+		module := e.modules[program.Name]
+		if module == nil {
+			if programName == "" {
+				continue
+			}
+
+			return nil, fmt.Errorf("module for program %q is not attached", program.Name)
+		}
+
+		if _, ok := tables[program.Name]; !ok {
+			tables[program.Name] = map[string]exportedTable{}
+		}
+
+		metricTables := map[string][]config.Label{}
+
+		for _, counter := range program.Metrics.Counters {
+			if counter.Table != "" {
+				metricTables[counter.Table] = counter.Labels
+			}
+		}
+
+		for _, histogram := range program.Metrics.Histograms {
+			if histogram.Table != "" {
+				metricTables[histogram.Table] = histogram.Labels
+			}
+		}
+
+		for name, labels := range metricTables {
+			if tableName != "" && name != tableName {
+				continue
+			}
+
+			metricValues, err := e.tableValues(module, name, labels)
+			if err != nil {
+				return nil, fmt.Errorf("error getting values for table %q of program %q: %s", name, program.Name, err)
+			}
+
+			tables[program.Name][name] = exportedTable{labels: labels, values: metricValues}
+		}
+	}
+
+	return tables, nil
+}
+
+// tablesJSON turns exported tables into a list sorted by program and table
+func tablesJSON(tables map[string]map[string]exportedTable, withKeyHex bool) []tableJSON {
+	result := []tableJSON{}
+
+	for program, programTables := range tables {
+		for name, table := range programTables {
+			labelNames := make([]string, len(table.labels))
+			for i, label := range table.labels {
+				labelNames[i] = label.Name
+			}
+
+			entries := make([]tableEntryJSON, len(table.values))
+			for i, row := range table.values {
+				entries[i] = tableEntryJSON{
+					Key:    row.raw,
+					Labels: map[string]string{},
+					Value:  row.value,
+				}
+
+				for idx, label := range labelNames {
+					entries[i].Labels[label] = row.labels[idx]
+				}
+
+				if withKeyHex {
+					entries[i].KeyHex = hex.EncodeToString(row.key)
+				}
+			}
+
+			sort.Slice(entries, func(i, j int) bool {
+				return entries[i].Key < entries[j].Key
+			})
+
+			result = append(result, tableJSON{
+				Program: program,
+				Table:   name,
+				Labels:  labelNames,
+				Entries: entries,
+			})
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Program != result[j].Program {
+			return result[i].Program < result[j].Program
+		}
+		return result[i].Table < result[j].Table
+	})
+
+	return result
+}
+
+// TablesHandler is a debug handler to print raw values of kernel maps.
+// It accepts the following query parameters:
+//
+// * format=json to get structured output instead of markdown
+// * program and table to only get some of the tables
+// * key_hex=true to include raw key bytes as hex
+func (e *Exporter) TablesHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	withKeyHex := false
+	if value := query.Get("key_hex"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid key_hex value %q: %s", value, err), http.StatusBadRequest)
+			return
+		}
+
+		withKeyHex = parsed
+	}
+
+	tables, err := e.exportTables(query.Get("program"), query.Get("table"))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Header().Add("Content-type", "text/plain")
+		if _, err = fmt.Fprintf(w, "%s\n", err); err != nil {
+			log.Printf("Error returning error to client %q: %s", r.RemoteAddr, err)
+			return
+		}
+		return
+	}
+
+	switch query.Get("format") {
+	case "json":
+		w.Header().Add("Content-type", "application/json")
+
+		if err = json.NewEncoder(w).Encode(tablesJSON(tables, withKeyHex)); err != nil {
+			log.Printf("Error returning table contents to client %q: %s", r.RemoteAddr, err)
+		}
+	case "", "markdown":
+		w.Header().Add("Content-type", "text/plain")
+
+		buf := []byte{}
+
+		for program, tables := range tables {
+			buf = append(buf, fmt.Sprintf("## Program: %s\n\n", program)...)
+
+			for name, table := range tables {
+				buf = append(buf, fmt.Sprintf("### Table: %s\n\n", name)...)
+
+				buf = append(buf, ("```\n")...)
+				for _, row := range table.values {
+					if withKeyHex {
+						buf = append(buf, fmt.Sprintf("%s [%x] (%v) -> %f\n", row.raw, row.key, row.labels, row.value)...)
+					} else {
+						buf = append(buf, fmt.Sprintf("%s (%v) -> %f\n", row.raw, row.labels, row.value)...)
+					}
+				}
+				buf = append(buf, ("```\n\n")...)
+			}
+		}
+
+		if _, err = w.Write(buf); err != nil {
+			log.Printf("Error returning table contents to client %q: %s", r.RemoteAddr, err)
+		}
+	default:
+		http.Error(w, fmt.Sprintf("unknown format %q", query.Get("format")), http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,80 @@
+package exporter
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ahas-sigs/kube-ebpf-exporter/config"
+	"github.com/iovisor/gobpf/bcc"
+)
+
+func TestTablesJSON(t *testing.T) {
+	labels := []config.Label{{Name: "disk"}, {Name: "op"}}
+
+	tables := map[string]map[string]exportedTable{
+		"bio": {
+			"io_latency": {
+				labels: labels,
+				values: []metricValue{
+					{key: []byte{0x01, 0x02}, raw: "{ 8 1 }", labels: []string{"sda", "write"}, value: 3},
+					{key: []byte{0x01, 0x00}, raw: "{ 8 0 }", labels: []string{"sda", "read"}, value: 5},
+				},
+			},
+		},
+		"accept": {
+			"accept_latency": {
+				labels: []config.Label{{Name: "port"}},
+			},
+		},
+	}
+
+	result := tablesJSON(tables, true)
+
+	expected := []tableJSON{
+		{
+			Program: "accept",
+			Table:   "accept_latency",
+			Labels:  []string{"port"},
+			Entries: []tableEntryJSON{},
+		},
+		{
+			Program: "bio",
+			Table:   "io_latency",
+			Labels:  []string{"disk", "op"},
+			Entries: []tableEntryJSON{
+				{Key: "{ 8 0 }", KeyHex: "0100", Labels: map[string]string{"disk": "sda", "op": "read"}, Value: 5},
+				{Key: "{ 8 1 }", KeyHex: "0102", Labels: map[string]string{"disk": "sda", "op": "write"}, Value: 3},
+			},
+		},
+	}
+
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected %#v, got %#v", expected, result)
+	}
+
+	if result := tablesJSON(tables, false); result[1].Entries[0].KeyHex != "" {
+		t.Errorf("Expected no key hex, got %q", result[1].Entries[0].KeyHex)
+	}
+}
+
+func TestExportTablesUnattached(t *testing.T) {
+	e := &Exporter{
+		config: config.Config{
+			Programs: []config.Program{{Name: "bio"}},
+		},
+		modules: map[string]*bcc.Module{},
+	}
+
+	tables, err := e.exportTables("", "")
+	if err != nil {
+		t.Fatalf("Error exporting tables of all programs: %s", err)
+	}
+
+	if len(tables) != 0 {
+		t.Errorf("Expected no tables for unattached programs, got %v", tables)
+	}
+
+	if _, err := e.exportTables("bio", ""); err == nil {
+		t.Errorf("Expected error exporting tables of unattached program, got nil")
+	}
+}
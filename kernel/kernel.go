@@ -0,0 +1,195 @@
+package kernel
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Host describes the running kernel from procfs, tracefs and kernel config,
+// symbols and kernel config are read once on the first lookup
+type Host struct {
+	release     string
+	kallsyms    string
+	tracingDirs []string
+	configPaths []string
+	symbolsOnce sync.Once
+	symbols     map[string]bool
+	symbolsErr  error
+	configOnce  sync.Once
+	config      map[string]string
+	configErr   error
+}
+
+// NewHost reads the release of the running kernel
+func NewHost() (*Host, error) {
+	release, err := ioutil.ReadFile("/proc/sys/kernel/osrelease")
+	if err != nil {
+		return nil, fmt.Errorf("error reading kernel release: %s", err)
+	}
+
+	name := strings.TrimSpace(string(release))
+
+	tracingDirs := []string{"/sys/kernel/debug/tracing", "/sys/kernel/tracing"}
+	configPaths := []string{"/proc/config.gz", "/boot/config-" + name}
+
+	return newHost(name, "/proc/kallsyms", tracingDirs, configPaths), nil
+}
+
+// newHost creates a Host reading kernel information from provided paths
+func newHost(release string, kallsyms string, tracingDirs []string, configPaths []string) *Host {
+	return &Host{
+		release:     release,
+		kallsyms:    kallsyms,
+		tracingDirs: tracingDirs,
+		configPaths: configPaths,
+	}
+}
+
+// Release returns the kernel release, like 5.4.0-100-generic
+func (h *Host) Release() string {
+	return h.release
+}
+
+// HasSymbol checks whether the kernel symbol is in kallsyms
+func (h *Host) HasSymbol(name string) (bool, error) {
+	h.symbolsOnce.Do(func() {
+		file, err := os.Open(h.kallsyms)
+		if err != nil {
+			h.symbolsErr = err
+			return
+		}
+
+		defer file.Close()
+
+		h.symbols, h.symbolsErr = parseKallsyms(file)
+	})
+
+	if h.symbolsErr != nil {
+		return false, h.symbolsErr
+	}
+
+	return h.symbols[name], nil
+}
+
+// HasTracepoint checks whether the tracepoint in category:name form exists
+func (h *Host) HasTracepoint(name string) (bool, error) {
+	parts := strings.Split(name, ":")
+	if len(parts) != 2 {
+		return false, fmt.Errorf("tracepoint %q is not in category:name form", name)
+	}
+
+	for _, dir := range h.tracingDirs {
+		if _, err := os.Stat(filepath.Join(dir, "events")); err != nil {
+			continue
+		}
+
+		_, err := os.Stat(filepath.Join(dir, "events", parts[0], parts[1]))
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+
+		return err == nil, err
+	}
+
+	return false, fmt.Errorf("tracefs is not found in any of %s", strings.Join(h.tracingDirs, ", "))
+}
+
+// HasConfig checks whether the kernel config option is built in or
+// built as a module, options can be named with or without CONFIG_ prefix
+func (h *Host) HasConfig(option string) (bool, error) {
+	h.configOnce.Do(func() {
+		h.config, h.configErr = h.readConfig()
+	})
+
+	if h.configErr != nil {
+		return false, h.configErr
+	}
+
+	if !strings.HasPrefix(option, "CONFIG_") {
+		option = "CONFIG_" + option
+	}
+
+	value := h.config[option]
+
+	return value == "y" || value == "m", nil
+}
+
+// readConfig reads kernel config from the first path that exists
+func (h *Host) readConfig() (map[string]string, error) {
+	for _, path := range h.configPaths {
+		file, err := os.Open(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		defer file.Close()
+
+		var r io.Reader = file
+
+		if strings.HasSuffix(path, ".gz") {
+			gz, err := gzip.NewReader(file)
+			if err != nil {
+				return nil, fmt.Errorf("error reading %q: %s", path, err)
+			}
+
+			defer gz.Close()
+
+			r = gz
+		}
+
+		return parseConfig(r)
+	}
+
+	return nil, fmt.Errorf("kernel config is not found in any of %s", strings.Join(h.configPaths, ", "))
+}
+
+// parseKallsyms returns names of symbols in /proc/kallsyms format,
+// symbols of modules are listed without their module
+func parseKallsyms(r io.Reader) (map[string]bool, error) {
+	symbols := map[string]bool{}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 {
+			continue
+		}
+
+		symbols[fields[2]] = true
+	}
+
+	return symbols, scanner.Err()
+}
+
+// parseConfig returns option values of kernel config in .config format
+func parseConfig(r io.Reader) (map[string]string, error) {
+	config := map[string]string{}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		config[parts[0]] = parts[1]
+	}
+
+	return config, scanner.Err()
+}
@@ -0,0 +1,81 @@
+package kernel
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHost(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kernel")
+	if err != nil {
+		t.Fatalf("Error creating temporary directory: %s", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	kallsyms := filepath.Join(dir, "kallsyms")
+	if err := ioutil.WriteFile(kallsyms, []byte("ffffffff81000000 T _stext\nffffffff81234560 t blk_account_io_done\nffffffffc0000000 t nf_conntrack_in\t[nf_conntrack]\n"), 0644); err != nil {
+		t.Fatalf("Error writing kallsyms: %s", err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(dir, "tracing", "events", "block", "block_rq_issue"), 0755); err != nil {
+		t.Fatalf("Error creating tracepoint: %s", err)
+	}
+
+	file, err := os.Create(filepath.Join(dir, "config.gz"))
+	if err != nil {
+		t.Fatalf("Error creating kernel config: %s", err)
+	}
+
+	gz := gzip.NewWriter(file)
+	gz.Write([]byte("# Comment\nCONFIG_BPF=y\nCONFIG_BPF_JIT=m\n# CONFIG_BPF_LSM is not set\nCONFIG_HZ=1000\n"))
+	gz.Close()
+	file.Close()
+
+	host := newHost("4.19.91-21.al7.x86_64", kallsyms, []string{filepath.Join(dir, "missing"), filepath.Join(dir, "tracing")}, []string{filepath.Join(dir, "config"), filepath.Join(dir, "config.gz")})
+
+	checks := []struct {
+		has      func(string) (bool, error)
+		name     string
+		expected bool
+	}{
+		{host.HasSymbol, "blk_account_io_done", true},
+		{host.HasSymbol, "nf_conntrack_in", true},
+		{host.HasSymbol, "blk_start_request", false},
+		{host.HasTracepoint, "block:block_rq_issue", true},
+		{host.HasTracepoint, "block:block_rq_insert", false},
+		{host.HasConfig, "CONFIG_BPF", true},
+		{host.HasConfig, "BPF_JIT", true},
+		{host.HasConfig, "BPF_LSM", false},
+		{host.HasConfig, "HZ", false},
+	}
+
+	for _, check := range checks {
+		has, err := check.has(check.name)
+		if err != nil {
+			t.Errorf("Error looking up %q: %s", check.name, err)
+			continue
+		}
+
+		if has != check.expected {
+			t.Errorf("Expected %q to be present: %v, got %v", check.name, check.expected, has)
+		}
+	}
+
+	missing := newHost("5.4.0", filepath.Join(dir, "missing"), nil, nil)
+
+	if _, err := missing.HasSymbol("_stext"); err == nil {
+		t.Errorf("Expected error looking up symbols without kallsyms")
+	}
+
+	if _, err := missing.HasTracepoint("block:block_rq_issue"); err == nil {
+		t.Errorf("Expected error looking up tracepoints without tracefs")
+	}
+
+	if _, err := missing.HasConfig("BPF"); err == nil {
+		t.Errorf("Expected error looking up kernel config without config file")
+	}
+}
@@ -0,0 +1,95 @@
+package kube
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// serviceAccountDir has credentials of the pod's service account
+	serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+	// requestTimeout limits how long a request to the API server takes
+	requestTimeout = 10 * time.Second
+)
+
+// node is the part of the Node object that we read
+type node struct {
+	Metadata struct {
+		Labels map[string]string `json:"labels"`
+	} `json:"metadata"`
+}
+
+// NodeLabels returns labels of the node from the API server,
+// using the service account of the pod the exporter runs in
+func NodeLabels(name string) (map[string]string, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running in a kubernetes cluster: KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT are not set")
+	}
+
+	token, err := ioutil.ReadFile(serviceAccountDir + "/token")
+	if err != nil {
+		return nil, fmt.Errorf("error reading service account token: %s", err)
+	}
+
+	ca, err := ioutil.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, fmt.Errorf("error reading service account ca: %s", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificates found in service account ca")
+	}
+
+	client := &http.Client{
+		Timeout: requestTimeout,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		},
+	}
+
+	return nodeLabels(client, "https://"+net.JoinHostPort(host, port), strings.TrimSpace(string(token)), name)
+}
+
+// nodeLabels requests the node from the API server at the address
+func nodeLabels(client *http.Client, address string, token string, name string) (map[string]string, error) {
+	req, err := http.NewRequest(http.MethodGet, address+"/api/v1/nodes/"+url.PathEscape(name), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error requesting node %q: %s", name, err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error requesting node %q: unexpected status %s", name, resp.Status)
+	}
+
+	n := node{}
+	if err := json.NewDecoder(resp.Body).Decode(&n); err != nil {
+		return nil, fmt.Errorf("error decoding node %q: %s", name, err)
+	}
+
+	if n.Metadata.Labels == nil {
+		return map[string]string{}, nil
+	}
+
+	return n.Metadata.Labels, nil
+}
@@ -0,0 +1,41 @@
+package kube
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNodeLabels(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if r.URL.Path != "/api/v1/nodes/node-1" {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Write([]byte(`{"kind":"Node","metadata":{"name":"node-1","labels":{"topology.kubernetes.io/zone":"zone-a"}}}`))
+	}))
+	defer server.Close()
+
+	labels, err := nodeLabels(server.Client(), server.URL, "secret", "node-1")
+	if err != nil {
+		t.Fatalf("Error requesting node labels: %s", err)
+	}
+
+	if labels["topology.kubernetes.io/zone"] != "zone-a" {
+		t.Errorf("Expected zone label zone-a, got %v", labels)
+	}
+
+	if _, err := nodeLabels(server.Client(), server.URL, "secret", "node-2"); err == nil {
+		t.Errorf("Expected error requesting missing node")
+	}
+
+	if _, err := nodeLabels(server.Client(), server.URL, "wrong", "node-1"); err == nil {
+		t.Errorf("Expected error requesting node without access")
+	}
+}